@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nextkey/nextkey/backend/internal/api"
@@ -15,6 +20,7 @@ import (
 	"github.com/nextkey/nextkey/backend/internal/database"
 	"github.com/nextkey/nextkey/backend/internal/middleware"
 	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/internal/store"
 	"github.com/nextkey/nextkey/backend/pkg/config"
 )
 
@@ -28,11 +34,20 @@ func main() {
 	middleware.SetJWTSecret(cfg.Security.JWTSecret)
 	service.SetJWTSecret(cfg.Security.JWTSecret)
 	middleware.SetReplayWindow(cfg.Security.ReplayWindow)
+	middleware.SetTrialDecrypt(!cfg.Security.DisableTrialDecrypt)
+	service.SetKeyGracePeriod(*cfg.Security.KeyGracePeriod)
+	service.SetSessionTTL(cfg.Security.SessionTTL)
+	service.SetLeaseGrace(cfg.Security.LeaseGrace)
+	service.SetTrashRetention(cfg.Trash.RetentionDays)
+	middleware.SetRateLimitRules(cfg.RateLimit.Rules)
 
 	if err := database.Initialize(cfg.Database.Path, cfg); err != nil {
 		log.Fatalf("数据库初始化失败: %v", err)
 	}
 
+	saveNonces := setupStores(cfg)
+	service.StartTrashCleaner()
+
 	if cfg.Server.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -76,8 +91,63 @@ func main() {
 	fmt.Printf("访问地址: http://localhost%s\n", addr)
 	fmt.Printf("默认账号: admin / admin123\n\n")
 
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("服务启动失败: %v", err)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("服务启动失败: %v", err)
+		}
+	}()
+
+	// 等待退出信号，处理完进行中的请求后再保存状态
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("正在关闭服务...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("服务关闭超时: %v", err)
+	}
+
+	saveNonces()
+}
+
+// setupStores 初始化限流与防重放的存储后端，两者配置相同时共用一个实例
+// 返回的函数在停机时调用，用于保存内存中的nonce
+func setupStores(cfg *config.Config) func() {
+	rateLimitStore, err := store.New(cfg.Store.RateLimit, cfg, database.DB)
+	if err != nil {
+		log.Fatalf("限流存储初始化失败: %v", err)
+	}
+	middleware.SetRateLimitStore(rateLimitStore)
+
+	nonceStore := rateLimitStore
+	if cfg.Store.Nonce != cfg.Store.RateLimit {
+		nonceStore, err = store.New(cfg.Store.Nonce, cfg, database.DB)
+		if err != nil {
+			log.Fatalf("防重放存储初始化失败: %v", err)
+		}
+	}
+	middleware.SetNonceStore(nonceStore)
+
+	memoryStore, ok := nonceStore.(*store.MemoryStore)
+	if !ok || *cfg.Store.NonceFile == "" {
+		return func() {}
+	}
+	nonceFile := *cfg.Store.NonceFile
+
+	if err := memoryStore.LoadFile(nonceFile); err != nil {
+		log.Printf("加载nonce缓存失败: %v", err)
+	}
+	return func() {
+		if err := memoryStore.SaveFile(nonceFile); err != nil {
+			log.Printf("保存nonce缓存失败: %v", err)
+		}
 	}
 }
 
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+// GetAnnouncements 客户端拉取当前生效的公告和消息，GET 请求或解析失败时返回全部
+func GetAnnouncements(c *gin.Context) {
+	var req struct {
+		UnreadOnly bool `json:"unread_only"`
+	}
+	_ = middleware.GetDecryptedData(c, &req)
+
+	announcementSvc := service.NewAnnouncementService()
+	list, err := announcementSvc.ClientList(currentToken(c), req.UnreadOnly)
+	if err != nil {
+		utils.EncryptedError(c, 500, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{"list": list})
+}
+
+func AckAnnouncements(c *gin.Context) {
+	var req struct {
+		IDs []uint `json:"ids"`
+	}
+	if err := middleware.GetDecryptedData(c, &req); err != nil || len(req.IDs) == 0 {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	announcementSvc := service.NewAnnouncementService()
+	acked, err := announcementSvc.Ack(currentToken(c), req.IDs)
+	if err != nil {
+		if errors.Is(err, service.ErrAnnouncementNoReader) {
+			utils.EncryptedError(c, 400, err.Error())
+			return
+		}
+		utils.EncryptedError(c, 500, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{"acked": acked})
+}
+
+func ListAnnouncements(c *gin.Context) {
+	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	cardID, _ := strconv.Atoi(c.DefaultQuery("card_id", "0"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	filter := &service.ListAnnouncementFilter{
+		ProjectID: uint(projectID),
+		CardID:    uint(cardID),
+		Scope:     c.Query("scope"),
+		Keyword:   c.Query("keyword"),
+	}
+
+	announcementSvc := service.NewAnnouncementService()
+	announcements, total, err := announcementSvc.List(filter, page, pageSize)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  announcements,
+		"total": total,
+		"page":  page,
+	})
+}
+
+func CreateAnnouncement(c *gin.Context) {
+	var req service.CreateAnnouncementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	announcementSvc := service.NewAnnouncementService()
+	announcement, err := announcementSvc.Create(&req, currentAdminID(c))
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, announcement)
+}
+
+func GetAnnouncement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	announcementSvc := service.NewAnnouncementService()
+	announcement, err := announcementSvc.Get(uint(id))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, announcement)
+}
+
+func UpdateAnnouncement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.UpdateAnnouncementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	announcementSvc := service.NewAnnouncementService()
+	announcement, err := announcementSvc.Update(uint(id), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrAnnouncementNotFound) {
+			utils.Error(c, 404, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, announcement)
+}
+
+func DeleteAnnouncement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	announcementSvc := service.NewAnnouncementService()
+	if err := announcementSvc.Delete(uint(id)); err != nil {
+		if errors.Is(err, service.ErrAnnouncementNotFound) {
+			utils.Error(c, 404, err.Error())
+			return
+		}
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
 	"github.com/nextkey/nextkey/backend/internal/service"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
 )
@@ -16,6 +17,11 @@ func CardLogin(c *gin.Context) {
 		return
 	}
 
+	if !middleware.MatchProjectUUID(c, &req.ProjectUUID) {
+		utils.EncryptedError(c, 401, "认证失败")
+		return
+	}
+
 	if req.IP == "" {
 		req.IP = c.ClientIP()
 	}
@@ -23,6 +29,9 @@ func CardLogin(c *gin.Context) {
 	authSvc := service.NewAuthService()
 	resp, err := authSvc.CardLogin(&req)
 	if err != nil {
+		if projectStatusError(c, err) {
+			return
+		}
 		utils.EncryptedError(c, 401, err.Error())
 		return
 	}
@@ -56,22 +65,24 @@ func AdminLogin(c *gin.Context) {
 }
 
 func Heartbeat(c *gin.Context) {
-	cardID, exists := c.Get("card_id")
-	projectID, _ := c.Get("project_id")
+	token := c.MustGet("token").(*models.Token)
 
-	// 免费模式下没有card_id,直接返回成功
-	if !exists {
-		utils.EncryptedSuccess(c, gin.H{"message": "心跳成功"})
-		return
-	}
+	// 旧客户端的心跳不带参数或发送空数组，解析失败时按不附带变更处理
+	var req service.HeartbeatRequest
+	_ = middleware.GetDecryptedData(c, &req)
 
 	cardSvc := service.NewCardService()
-	if err := cardSvc.Heartbeat(cardID.(uint), projectID.(uint)); err != nil {
+	resp, err := cardSvc.Heartbeat(token, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrCardUnavailable) {
+			utils.EncryptedError(c, 401, err.Error())
+			return
+		}
 		utils.EncryptedError(c, 500, err.Error())
 		return
 	}
 
-	utils.EncryptedSuccess(c, gin.H{"message": "心跳成功"})
+	utils.EncryptedSuccess(c, resp)
 }
 
 func AdminRefreshToken(c *gin.Context) {
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
 	"github.com/nextkey/nextkey/backend/internal/service"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
 )
@@ -16,21 +18,62 @@ func UpdateCardCustomData(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		CustomData string `json:"custom_data"`
-	}
+	var req service.UpdateCustomDataRequest
 	if err := middleware.GetDecryptedData(c, &req); err != nil {
 		utils.EncryptedError(c, 400, "参数错误")
 		return
 	}
 
 	cardSvc := service.NewCardService()
-	if err := cardSvc.UpdateCustomData(cardID.(uint), req.CustomData); err != nil {
-		utils.EncryptedError(c, 500, err.Error())
+	resp, err := cardSvc.UpdateCustomData(cardID.(uint), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrCustomDataConflict) {
+			utils.EncryptedError(c, 409, err.Error())
+			return
+		}
+		utils.EncryptedError(c, 400, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{
+		"message": "更新成功",
+		"version": resp.Version,
+	})
+}
+
+func GetCardCustomData(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	cardSvc := service.NewCardService()
+	resp, err := cardSvc.GetCustomData(cardID.(uint))
+	if err != nil {
+		utils.EncryptedError(c, 404, err.Error())
 		return
 	}
 
-	utils.EncryptedSuccess(c, gin.H{"message": "更新成功"})
+	utils.EncryptedSuccess(c, resp)
+}
+
+func GetEntitlements(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		// 免费模式没有卡密，返回空权益
+		utils.EncryptedSuccess(c, models.ResolveEntitlements(nil, nil))
+		return
+	}
+
+	cardSvc := service.NewCardService()
+	entitlements, err := cardSvc.GetEntitlements(cardID.(uint))
+	if err != nil {
+		utils.EncryptedError(c, 404, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, entitlements)
 }
 
 func CreateCards(c *gin.Context) {
@@ -268,6 +311,11 @@ func UnbindCardHWID(c *gin.Context) {
 		return
 	}
 
+	if !middleware.MatchProjectUUID(c, &req.ProjectUUID) {
+		utils.EncryptedError(c, 400, "项目不匹配")
+		return
+	}
+
 	cardSvc := service.NewCardService()
 	if err := cardSvc.UnbindHWID(&req); err != nil {
 		utils.EncryptedError(c, 400, err.Error())
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+func ListCardTemplates(c *gin.Context) {
+	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	templateSvc := service.NewCardTemplateService()
+	templates, total, err := templateSvc.List(uint(projectID), page, pageSize)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  templates,
+		"total": total,
+		"page":  page,
+	})
+}
+
+func CreateCardTemplate(c *gin.Context) {
+	var req service.CreateCardTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	templateSvc := service.NewCardTemplateService()
+	template, err := templateSvc.Create(&req)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, template)
+}
+
+func GetCardTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	templateSvc := service.NewCardTemplateService()
+	template, err := templateSvc.Get(uint(id))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, template)
+}
+
+func UpdateCardTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.UpdateCardTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	templateSvc := service.NewCardTemplateService()
+	template, err := templateSvc.Update(uint(id), &req)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, template)
+}
+
+func DeleteCardTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	templateSvc := service.NewCardTemplateService()
+	if err := templateSvc.Delete(uint(id)); err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "删除成功"})
+}
@@ -1,19 +1,37 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
 	"github.com/nextkey/nextkey/backend/internal/service"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
 )
 
+// currentAdminID 返回 AdminAuthMiddleware 写入的管理员ID
+func currentAdminID(c *gin.Context) uint {
+	adminID, _ := c.Get("admin_id")
+	id, _ := adminID.(float64)
+	return uint(id)
+}
+
+// currentToken 返回 AuthMiddleware 写入的客户端Token，公开接口为空
+func currentToken(c *gin.Context) *models.Token {
+	token, _ := c.Get("token")
+	t, _ := token.(*models.Token)
+	return t
+}
+
 func GetCloudVar(c *gin.Context) {
 	projectID, _ := c.Get("project_id")
 	key := c.Param("key")
 
 	cloudVarSvc := service.NewCloudVarService()
-	cloudVar, err := cloudVarSvc.Get(projectID.(uint), key)
+	cloudVar, err := cloudVarSvc.Get(projectID.(uint), currentToken(c), key)
 	if err != nil {
 		utils.EncryptedError(c, 404, err.Error())
 		return
@@ -22,6 +40,48 @@ func GetCloudVar(c *gin.Context) {
 	utils.EncryptedSuccess(c, cloudVar)
 }
 
+// GetCloudVars 批量读取变量，keys 为空时返回全部可见变量
+func GetCloudVars(c *gin.Context) {
+	projectID, _ := c.Get("project_id")
+
+	var req service.BulkGetCloudVarsRequest
+	if err := middleware.GetDecryptedData(c, &req); err != nil {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	bundle, err := cloudVarSvc.BulkGet(projectID.(uint), currentToken(c), &req)
+	if err != nil {
+		utils.EncryptedError(c, 400, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, bundle)
+}
+
+// GetCloudVarChanges 返回修订号 since 之后变化的变量
+func GetCloudVarChanges(c *gin.Context) {
+	projectID, _ := c.Get("project_id")
+
+	var req struct {
+		Since int64 `json:"since"`
+	}
+	if err := middleware.GetDecryptedData(c, &req); err != nil {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	changes, err := cloudVarSvc.Changes(projectID.(uint), currentToken(c), req.Since)
+	if err != nil {
+		utils.EncryptedError(c, 400, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, changes)
+}
+
 func SetCloudVar(c *gin.Context) {
 	var req service.CreateCloudVarRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -30,9 +90,13 @@ func SetCloudVar(c *gin.Context) {
 	}
 
 	cloudVarSvc := service.NewCloudVarService()
-	cloudVar, err := cloudVarSvc.Set(&req)
+	cloudVar, err := cloudVarSvc.Set(&req, currentAdminID(c))
 	if err != nil {
-		utils.Error(c, 500, err.Error())
+		if errors.Is(err, service.ErrCloudVarConflict) {
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
 		return
 	}
 
@@ -41,11 +105,21 @@ func SetCloudVar(c *gin.Context) {
 
 func ListCloudVars(c *gin.Context) {
 	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	templateID, _ := strconv.Atoi(c.DefaultQuery("template_id", "0"))
+	cardID, _ := strconv.Atoi(c.DefaultQuery("card_id", "0"))
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 
+	filter := &service.ListCloudVarsFilter{
+		ProjectID:  uint(projectID),
+		Scope:      c.Query("scope"),
+		TemplateID: uint(templateID),
+		CardID:     uint(cardID),
+		Key:        c.Query("key"),
+	}
+
 	cloudVarSvc := service.NewCloudVarService()
-	cloudVars, total, err := cloudVarSvc.List(uint(projectID), page, pageSize)
+	cloudVars, total, err := cloudVarSvc.List(filter, page, pageSize)
 	if err != nil {
 		utils.Error(c, 500, err.Error())
 		return
@@ -80,8 +154,17 @@ func BatchSetCloudVars(c *gin.Context) {
 	}
 
 	cloudVarSvc := service.NewCloudVarService()
-	if err := cloudVarSvc.BatchSet(req.Data); err != nil {
-		utils.Error(c, 500, err.Error())
+	if err := cloudVarSvc.BatchSet(req.Data, currentAdminID(c)); err != nil {
+		var batchErr *service.CloudVarBatchError
+		if errors.As(err, &batchErr) {
+			utils.ErrorWithData(c, 400, batchErr.Error(), gin.H{"errors": batchErr.Errors})
+			return
+		}
+		if errors.Is(err, service.ErrCloudVarConflict) {
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
 		return
 	}
 
@@ -105,3 +188,201 @@ func BatchDeleteCloudVars(c *gin.Context) {
 
 	utils.Success(c, gin.H{"message": "批量删除成功"})
 }
+
+func ListCardVars(c *gin.Context) {
+	if _, exists := c.Get("card_id"); !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	cloudVars, err := cloudVarSvc.ListCardVars(currentToken(c))
+	if err != nil {
+		utils.EncryptedError(c, 500, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{"list": cloudVars})
+}
+
+func SetCardVar(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	var req service.SetCardVarRequest
+	if err := middleware.GetDecryptedData(c, &req); err != nil {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	cloudVar, err := cloudVarSvc.SetCardVar(cardID.(uint), &req)
+	if err != nil {
+		utils.EncryptedError(c, 400, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, cloudVar)
+}
+
+func DeleteCardVar(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := middleware.GetDecryptedData(c, &req); err != nil {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	if err := cloudVarSvc.DeleteCardVar(cardID.(uint), req.Key); err != nil {
+		utils.EncryptedError(c, 404, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{"message": "删除成功"})
+}
+
+func ListCloudVarVersions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	cloudVarSvc := service.NewCloudVarService()
+	versions, total, err := cloudVarSvc.ListVersions(uint(id), page, pageSize)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  versions,
+		"total": total,
+		"page":  page,
+	})
+}
+
+func DiffCloudVarVersions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, _ := strconv.Atoi(c.Query("to"))
+
+	cloudVarSvc := service.NewCloudVarService()
+	diff, err := cloudVarSvc.Diff(uint(id), from, to)
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, diff)
+}
+
+func RollbackCloudVar(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.RollbackCloudVarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	cloudVar, err := cloudVarSvc.Rollback(uint(id), &req, currentAdminID(c))
+	if err != nil {
+		if errors.Is(err, service.ErrCloudVarConflict) {
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, cloudVar)
+}
+
+func ListCloudVarSegments(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	cardID, _ := strconv.Atoi(c.DefaultQuery("card_id", "0"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	cloudVarSvc := service.NewCloudVarService()
+	segments, total, err := cloudVarSvc.Segments(uint(id), uint(cardID), page, pageSize)
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  segments,
+		"total": total,
+		"page":  page,
+	})
+}
+
+// ExportCloudVars 导出项目的云变量，返回文件名和文本内容
+func ExportCloudVars(c *gin.Context) {
+	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	format := c.DefaultQuery("format", "json")
+
+	cloudVarSvc := service.NewCloudVarService()
+	export, err := cloudVarSvc.Export(uint(projectID))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+	content, err := export.Marshal(format)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	if format == "yml" {
+		format = "yaml"
+	}
+
+	utils.Success(c, gin.H{
+		"filename": fmt.Sprintf("cloud-vars-%d-%s.%s", projectID, export.ExportedAt.Format("20060102150405"), format),
+		"content":  string(content),
+		"count":    len(export.Vars),
+		"skipped":  export.Skipped,
+	})
+}
+
+func ImportCloudVars(c *gin.Context) {
+	var req service.ImportCloudVarsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	cloudVarSvc := service.NewCloudVarService()
+	result, err := cloudVarSvc.Import(&req, currentAdminID(c))
+	if err != nil {
+		var batchErr *service.CloudVarBatchError
+		if errors.As(err, &batchErr) {
+			utils.ErrorWithData(c, 400, batchErr.Error(), gin.H{"errors": batchErr.Errors})
+			return
+		}
+		if errors.Is(err, service.ErrCloudVarImportConflict) {
+			utils.ErrorWithData(c, 409, err.Error(), result)
+			return
+		}
+		if errors.Is(err, service.ErrCloudVarConflict) {
+			utils.Error(c, 409, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, result)
+}
@@ -15,6 +15,35 @@ func GetEncryptionSchemes(c *gin.Context) {
 	utils.Success(c, schemes)
 }
 
+// GetSigningKey 获取项目响应签名公钥及指纹，客户端据此核对内置公钥
+func GetSigningKey(c *gin.Context) {
+	projectUUID := c.Query("project_uuid")
+	if projectUUID == "" {
+		utils.Error(c, 400, "项目UUID不能为空")
+		return
+	}
+
+	projectSvc := service.NewProjectService()
+	project, err := projectSvc.GetByUUID(projectUUID)
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	signer, err := crypto.NewSigner(project.SigningKey)
+	if err != nil {
+		utils.Error(c, 500, "项目未配置签名密钥")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"project_uuid": project.UUID,
+		"algorithm":    crypto.SigningAlgorithm,
+		"public_key":   signer.PublicKeyBase64(),
+		"fingerprint":  signer.Fingerprint(),
+	})
+}
+
 // UpdateProjectEncryption 修改项目的加密方案
 func UpdateProjectEncryption(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
@@ -42,6 +71,111 @@ func UpdateProjectEncryption(c *gin.Context) {
 	utils.Success(c, gin.H{
 		"encryption_scheme": project.EncryptionScheme,
 		"encryption_key":    project.EncryptionKey,
+		"key_version":       project.KeyVersion,
+		"public_key":        project.PublicKey,
 	})
 }
 
+// Handshake 密钥交换方案握手，建立会话
+func Handshake(c *gin.Context) {
+	var req service.HandshakeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	if req.ProjectUUID == "" || req.ClientPublicKey == "" {
+		utils.Error(c, 400, "项目UUID和客户端公钥不能为空")
+		return
+	}
+
+	handshakeSvc := service.NewHandshakeService()
+	resp, err := handshakeSvc.Handshake(&req)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, resp)
+}
+
+// ListProjectKeys 获取项目的全部密钥版本
+func ListProjectKeys(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	projectSvc := service.NewProjectService()
+	keys, err := projectSvc.ListKeys(uint(id))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, keys)
+}
+
+// StageProjectKey 生成新版本密钥，暂不激活
+func StageProjectKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req struct {
+		EncryptionScheme string `json:"encryption_scheme"` // 为空时沿用当前方案
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	projectSvc := service.NewProjectService()
+	key, err := projectSvc.StageKey(uint(id), req.EncryptionScheme)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, key)
+}
+
+// ActivateProjectKey 激活指定版本密钥，原活动密钥进入宽限期
+func ActivateProjectKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, _ := strconv.Atoi(c.Param("version"))
+
+	var req struct {
+		GracePeriod *int `json:"grace_period"` // 秒，为空时使用配置的默认值
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.Error(c, 400, "参数错误")
+			return
+		}
+	}
+
+	projectSvc := service.NewProjectService()
+	project, err := projectSvc.ActivateKey(uint(id), version, req.GracePeriod)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"encryption_scheme": project.EncryptionScheme,
+		"encryption_key":    project.EncryptionKey,
+		"key_version":       project.KeyVersion,
+		"public_key":        project.PublicKey,
+	})
+}
+
+// RetireProjectKey 立即停用指定版本密钥
+func RetireProjectKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, _ := strconv.Atoi(c.Param("version"))
+
+	projectSvc := service.NewProjectService()
+	if err := projectSvc.RetireKey(uint(id), version); err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, nil)
+}
+
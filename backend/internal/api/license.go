@@ -0,0 +1,104 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+// ExchangeLicense 用离线授权换取在线会话
+func ExchangeLicense(c *gin.Context) {
+	var req service.ExchangeLicenseRequest
+	if err := middleware.GetDecryptedData(c, &req); err != nil {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	if !middleware.MatchProjectUUID(c, &req.ProjectUUID) {
+		utils.EncryptedError(c, 401, "认证失败")
+		return
+	}
+
+	projectID, _ := c.Get("project_id")
+
+	licenseSvc := service.NewLicenseService()
+	resp, err := licenseSvc.Exchange(projectID.(uint), &req)
+	if err != nil {
+		if projectStatusError(c, err) {
+			return
+		}
+		utils.EncryptedError(c, 401, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, resp)
+}
+
+// IssueOfflineLicense 为卡密签发离线授权
+func IssueOfflineLicense(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.IssueLicenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	licenseSvc := service.NewLicenseService()
+	record, err := licenseSvc.Issue(uint(id), &req)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, record)
+}
+
+func ListOfflineLicenses(c *gin.Context) {
+	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	cardID, _ := strconv.Atoi(c.DefaultQuery("card_id", "0"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	licenseSvc := service.NewLicenseService()
+	licenses, total, err := licenseSvc.List(uint(projectID), uint(cardID), page, pageSize)
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  licenses,
+		"total": total,
+	})
+}
+
+// DownloadOfflineLicense 以文件形式下载授权内容
+func DownloadOfflineLicense(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	licenseSvc := service.NewLicenseService()
+	record, err := licenseSvc.GetByID(uint(id))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\""+record.LicenseID+".lic\"")
+	c.Data(200, "application/octet-stream", []byte(record.Content))
+}
+
+func RevokeOfflineLicense(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	licenseSvc := service.NewLicenseService()
+	if err := licenseSvc.Revoke(uint(id)); err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, nil)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -27,6 +28,16 @@ func GetProjectInfo(c *gin.Context) {
 	})
 }
 
+// projectStatusError 项目维护或停用时返回加密的状态和提示
+func projectStatusError(c *gin.Context, err error) bool {
+	var statusErr *service.ProjectStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	utils.EncryptedErrorWithData(c, statusErr.Code, statusErr.Info.Message, statusErr.Info)
+	return true
+}
+
 func CreateProject(c *gin.Context) {
 	var req service.CreateProjectRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -125,6 +136,63 @@ func DeleteProject(c *gin.Context) {
 	utils.Success(c, gin.H{"message": "删除成功"})
 }
 
+func UpdateProjectStatus(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.UpdateProjectStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	projectSvc := service.NewProjectService()
+	project, err := projectSvc.UpdateStatus(uint(id), &req)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, project)
+}
+
+func PreviewDeleteProjects(c *gin.Context) {
+	var req struct {
+		IDs []uint `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	projectSvc := service.NewProjectService()
+	preview, err := projectSvc.DeletePreview(req.IDs)
+	if err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, preview)
+}
+
+func CloneProject(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req service.CloneProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, 400, "参数错误")
+		return
+	}
+
+	projectSvc := service.NewProjectService()
+	result, err := projectSvc.Clone(uint(id), &req, currentAdminID(c))
+	if err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, result)
+}
+
 func BatchCreateProjects(c *gin.Context) {
 	var req struct {
 		Data []service.CreateProjectRequest `json:"data"`
@@ -8,27 +8,54 @@ import (
 func RegisterRoutes(r *gin.Engine) {
 	api := r.Group("/api")
 	{
-		api.POST("/auth/login", middleware.LoginRateLimitMiddleware(), middleware.DecryptMiddleware(), CardLogin)
+		api.POST("/auth/login", middleware.RateLimitMiddleware("login"), middleware.DecryptMiddleware(), CardLogin)
 		api.GET("/crypto/schemes", GetEncryptionSchemes)
-		api.POST("/card/unbind", middleware.DecryptMiddleware(), UnbindCardHWID)
-		api.POST("/card/unbind-public", UnbindCardHWIDPublic)
+		api.GET("/crypto/signing-key", GetSigningKey)
+		api.POST("/crypto/handshake", middleware.RateLimitMiddleware("handshake"), Handshake)
+		api.POST("/card/unbind", middleware.RateLimitMiddleware("unbind"), middleware.DecryptMiddleware(), UnbindCardHWID)
+		api.POST("/card/unbind-public", middleware.RateLimitMiddleware("unbind"), UnbindCardHWIDPublic)
+		api.POST("/license/exchange", middleware.RateLimitMiddleware("login"), middleware.DecryptMiddleware(), ExchangeLicense)
+		// 登录前读取公开云变量
+		api.GET("/public/cloud-var/:key", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVar)
+		api.POST("/public/cloud-var/:key", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVar)
+		api.GET("/public/cloud-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVars)
+		api.POST("/public/cloud-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVars)
+		api.GET("/public/cloud-vars/changes", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVarChanges)
+		api.POST("/public/cloud-vars/changes", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVarChanges)
 
 		authenticated := api.Group("")
 		authenticated.Use(middleware.AuthMiddleware())
 		{
 			// 需要加密的请求
-			authenticated.POST("/heartbeat", middleware.DecryptMiddleware(), Heartbeat)
-			authenticated.POST("/card/custom-data", middleware.DecryptMiddleware(), UpdateCardCustomData)
-			authenticated.GET("/cloud-var/:key", middleware.DecryptMiddleware(), GetCloudVar)
-			authenticated.POST("/cloud-var/:key", middleware.DecryptMiddleware(), GetCloudVar)
-			authenticated.GET("/project/info", middleware.DecryptMiddleware(), GetProjectInfo)
-			authenticated.POST("/project/info", middleware.DecryptMiddleware(), GetProjectInfo)
+			authenticated.POST("/heartbeat", middleware.RateLimitMiddleware("heartbeat"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), Heartbeat)
+			authenticated.POST("/card/custom-data", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), UpdateCardCustomData)
+			authenticated.GET("/card/custom-data", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCardCustomData)
+			authenticated.GET("/cloud-var/:key", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVar)
+			authenticated.POST("/cloud-var/:key", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVar)
+			authenticated.GET("/cloud-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVars)
+			authenticated.POST("/cloud-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVars)
+			authenticated.GET("/cloud-vars/changes", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVarChanges)
+			authenticated.POST("/cloud-vars/changes", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetCloudVarChanges)
+			authenticated.GET("/card-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), ListCardVars)
+			authenticated.POST("/card-vars", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), ListCardVars)
+			authenticated.POST("/card-vars/set", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), SetCardVar)
+			authenticated.POST("/card-vars/delete", middleware.RateLimitMiddleware("cloud_var"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), DeleteCardVar)
+			authenticated.GET("/project/info", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetProjectInfo)
+			authenticated.POST("/project/info", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetProjectInfo)
+			authenticated.GET("/announcements", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetAnnouncements)
+			authenticated.POST("/announcements", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetAnnouncements)
+			authenticated.POST("/announcements/ack", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), AckAnnouncements)
+			authenticated.GET("/entitlements", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetEntitlements)
+			authenticated.POST("/entitlements", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetEntitlements)
+			authenticated.POST("/usage/report", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), ReportUsage)
+			authenticated.GET("/usage", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetUsage)
+			authenticated.POST("/usage", middleware.RateLimitMiddleware("client"), middleware.DecryptMiddleware(), middleware.ProjectStatusMiddleware(), GetUsage)
 		}
 	}
 
 	admin := r.Group("/admin")
 	{
-		admin.POST("/login", middleware.LoginRateLimitMiddleware(), AdminLogin)
+		admin.POST("/login", middleware.RateLimitMiddleware("admin_login"), AdminLogin)
 		admin.POST("/refresh", AdminRefreshToken)
 
 		adminAuth := admin.Group("")
@@ -40,10 +67,17 @@ func RegisterRoutes(r *gin.Engine) {
 			adminAuth.POST("/projects", CreateProject)
 			adminAuth.PUT("/projects/:id", UpdateProject)
 			adminAuth.DELETE("/projects/:id", DeleteProject)
-			adminAuth.GET("/projects/:uuid", GetProjectByUUID)
+			adminAuth.GET("/projects/by-uuid/:uuid", GetProjectByUUID)
 			adminAuth.POST("/projects/batch", BatchCreateProjects)
 			adminAuth.DELETE("/projects/batch", BatchDeleteProjects)
+			adminAuth.POST("/projects/delete-preview", PreviewDeleteProjects)
+			adminAuth.POST("/projects/:id/clone", CloneProject)
+			adminAuth.PUT("/projects/:id/status", UpdateProjectStatus)
 			adminAuth.POST("/projects/:id/encryption", UpdateProjectEncryption)
+			adminAuth.GET("/projects/:id/keys", ListProjectKeys)
+			adminAuth.POST("/projects/:id/keys", StageProjectKey)
+			adminAuth.POST("/projects/:id/keys/:version/activate", ActivateProjectKey)
+			adminAuth.POST("/projects/:id/keys/:version/retire", RetireProjectKey)
 
 			adminAuth.GET("/cards", ListCards)
 			adminAuth.POST("/cards", CreateCards)
@@ -56,12 +90,41 @@ func RegisterRoutes(r *gin.Engine) {
 			adminAuth.DELETE("/cards/batch", BatchDeleteCards)
 			adminAuth.PUT("/cards/batch/freeze", BatchFreezeCards)
 			adminAuth.PUT("/cards/batch/unfreeze", BatchUnfreezeCards)
+			adminAuth.POST("/cards/:id/offline-license", IssueOfflineLicense)
+			adminAuth.GET("/cards/:id/usage", GetCardUsage)
+			adminAuth.POST("/cards/:id/usage/:meter/reset", ResetCardUsage)
+
+			adminAuth.GET("/card-templates", ListCardTemplates)
+			adminAuth.POST("/card-templates", CreateCardTemplate)
+			adminAuth.GET("/card-templates/:id", GetCardTemplate)
+			adminAuth.PUT("/card-templates/:id", UpdateCardTemplate)
+			adminAuth.DELETE("/card-templates/:id", DeleteCardTemplate)
+
+			adminAuth.GET("/offline-licenses", ListOfflineLicenses)
+			adminAuth.GET("/offline-licenses/:id/download", DownloadOfflineLicense)
+			adminAuth.POST("/offline-licenses/:id/revoke", RevokeOfflineLicense)
 
 			adminAuth.GET("/cloud-vars", ListCloudVars)
 			adminAuth.POST("/cloud-vars", SetCloudVar)
 			adminAuth.DELETE("/cloud-vars/:id", DeleteCloudVar)
+			adminAuth.GET("/cloud-vars/:id/versions", ListCloudVarVersions)
+			adminAuth.GET("/cloud-vars/:id/diff", DiffCloudVarVersions)
+			adminAuth.POST("/cloud-vars/:id/rollback", RollbackCloudVar)
+			adminAuth.GET("/cloud-vars/:id/segments", ListCloudVarSegments)
 			adminAuth.POST("/cloud-vars/batch", BatchSetCloudVars)
+			adminAuth.GET("/cloud-vars/export", ExportCloudVars)
+			adminAuth.POST("/cloud-vars/import", ImportCloudVars)
 			adminAuth.DELETE("/cloud-vars/batch", BatchDeleteCloudVars)
+
+			adminAuth.GET("/announcements", ListAnnouncements)
+			adminAuth.POST("/announcements", CreateAnnouncement)
+			adminAuth.GET("/announcements/:id", GetAnnouncement)
+			adminAuth.PUT("/announcements/:id", UpdateAnnouncement)
+			adminAuth.DELETE("/announcements/:id", DeleteAnnouncement)
+
+			adminAuth.GET("/trash", ListTrash)
+			adminAuth.POST("/trash/:type/:id/restore", RestoreTrash)
+			adminAuth.DELETE("/trash/:type/:id", PurgeTrash)
 		}
 	}
 }
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+func ListTrash(c *gin.Context) {
+	projectID, _ := strconv.Atoi(c.DefaultQuery("project_id", "0"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	filter := &service.ListTrashFilter{
+		Type:      c.DefaultQuery("type", service.TrashTypeProject),
+		ProjectID: uint(projectID),
+		Keyword:   c.Query("keyword"),
+	}
+
+	trashSvc := service.NewTrashService()
+	items, total, err := trashSvc.List(filter, page, pageSize)
+	if err != nil {
+		if errors.Is(err, service.ErrTrashInvalidType) {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"list":  items,
+		"total": total,
+		"page":  page,
+	})
+}
+
+func RestoreTrash(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	trashSvc := service.NewTrashService()
+	result, err := trashSvc.Restore(c.Param("type"), uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrTrashNotFound) {
+			utils.Error(c, 404, err.Error())
+			return
+		}
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	utils.Success(c, result)
+}
+
+func PurgeTrash(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	trashSvc := service.NewTrashService()
+	if err := trashSvc.Purge(c.Param("type"), uint(id)); err != nil {
+		if errors.Is(err, service.ErrTrashNotFound) {
+			utils.Error(c, 404, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrTrashInvalidType) {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "已彻底删除"})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/service"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+func ReportUsage(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	var req service.ReportUsageRequest
+	if err := middleware.GetDecryptedData(c, &req); err != nil || req.Meter == "" {
+		utils.EncryptedError(c, 400, "参数错误")
+		return
+	}
+
+	usageSvc := service.NewUsageService()
+	balance, err := usageSvc.Report(cardID.(uint), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrQuotaExceeded) {
+			utils.EncryptedError(c, 403, err.Error())
+			return
+		}
+		utils.EncryptedError(c, 400, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, balance)
+}
+
+func GetUsage(c *gin.Context) {
+	cardID, exists := c.Get("card_id")
+	if !exists {
+		utils.EncryptedError(c, 400, "免费模式不支持此功能")
+		return
+	}
+
+	usageSvc := service.NewUsageService()
+	balances, err := usageSvc.Balances(cardID.(uint))
+	if err != nil {
+		utils.EncryptedError(c, 404, err.Error())
+		return
+	}
+
+	utils.EncryptedSuccess(c, gin.H{"list": balances})
+}
+
+func GetCardUsage(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	usageSvc := service.NewUsageService()
+	balances, err := usageSvc.Balances(uint(id))
+	if err != nil {
+		utils.Error(c, 404, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{"list": balances})
+}
+
+func ResetCardUsage(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	usageSvc := service.NewUsageService()
+	if err := usageSvc.Reset(uint(id), c.Param("meter")); err != nil {
+		utils.Error(c, 500, err.Error())
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "重置成功"})
+}
@@ -27,8 +27,9 @@ func init() {
 	})
 }
 
+// AESEncryptor 创建时构建AEAD实例，Seal/Open不修改内部状态，可并发复用
 type AESEncryptor struct {
-	key []byte
+	aead cipher.AEAD
 }
 
 func NewAESEncryptor(key string) (*AESEncryptor, error) {
@@ -39,7 +40,16 @@ func NewAESEncryptor(key string) (*AESEncryptor, error) {
 	if len(keyBytes) != 32 {
 		return nil, errors.New("AES密钥必须为32字节")
 	}
-	return &AESEncryptor{key: keyBytes}, nil
+
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESEncryptor{aead: aead}, nil
 }
 
 func (e *AESEncryptor) Scheme() string {
@@ -64,15 +74,7 @@ func decodeKey(key string) ([]byte, error) {
 }
 
 func (e *AESEncryptor) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(e.key)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
+	gcm := e.aead
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
@@ -89,15 +91,7 @@ func (e *AESEncryptor) Decrypt(ciphertext string) (string, error) {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(e.key)
-	if err != nil {
-		return "", err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
+	gcm := e.aead
 
 	nonceSize := gcm.NonceSize()
 	if len(data) < nonceSize {
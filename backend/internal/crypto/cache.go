@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"sync"
+)
+
+type encryptorCacheKey struct {
+	projectID  uint
+	keyVersion int
+}
+
+type encryptorCacheEntry struct {
+	scheme    string
+	key       string
+	encryptor Encryptor
+}
+
+var encryptorCache = struct {
+	sync.RWMutex
+	entries map[encryptorCacheKey]*encryptorCacheEntry
+}{
+	entries: make(map[encryptorCacheKey]*encryptorCacheEntry),
+}
+
+// GetProjectEncryptor 获取项目加密器，相同项目和密钥版本复用同一实例
+// 缓存项同时校验方案和密钥，版本号未变但密钥被改写时也会重建
+func GetProjectEncryptor(projectID uint, keyVersion int, scheme, key string) (Encryptor, error) {
+	cacheKey := encryptorCacheKey{projectID: projectID, keyVersion: keyVersion}
+
+	encryptorCache.RLock()
+	entry, exists := encryptorCache.entries[cacheKey]
+	encryptorCache.RUnlock()
+
+	if exists && entry.scheme == scheme && entry.key == key {
+		return entry.encryptor, nil
+	}
+
+	encryptor, err := NewEncryptor(scheme, key)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptorCache.Lock()
+	encryptorCache.entries[cacheKey] = &encryptorCacheEntry{
+		scheme:    scheme,
+		key:       key,
+		encryptor: encryptor,
+	}
+	encryptorCache.Unlock()
+
+	return encryptor, nil
+}
+
+// InvalidateProjectEncryptors 清除项目所有密钥版本的加密器
+func InvalidateProjectEncryptors(projectID uint) {
+	encryptorCache.Lock()
+	defer encryptorCache.Unlock()
+
+	for cacheKey := range encryptorCache.entries {
+		if cacheKey.projectID == projectID {
+			delete(encryptorCache.entries, cacheKey)
+		}
+	}
+}
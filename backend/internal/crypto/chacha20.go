@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
@@ -27,8 +28,9 @@ func init() {
 	})
 }
 
+// ChaCha20Encryptor 创建时构建AEAD实例，Seal/Open不修改内部状态，可并发复用
 type ChaCha20Encryptor struct {
-	key []byte
+	aead cipher.AEAD
 }
 
 func NewChaCha20Encryptor(key string) (*ChaCha20Encryptor, error) {
@@ -39,7 +41,12 @@ func NewChaCha20Encryptor(key string) (*ChaCha20Encryptor, error) {
 	if len(keyBytes) != chacha20poly1305.KeySize {
 		return nil, errors.New("ChaCha20密钥必须为32字节")
 	}
-	return &ChaCha20Encryptor{key: keyBytes}, nil
+
+	aead, err := chacha20poly1305.New(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaCha20Encryptor{aead: aead}, nil
 }
 
 func (e *ChaCha20Encryptor) Scheme() string {
@@ -53,10 +60,7 @@ func generateChaCha20Key() string {
 }
 
 func (e *ChaCha20Encryptor) Encrypt(plaintext string) (string, error) {
-	aead, err := chacha20poly1305.New(e.key)
-	if err != nil {
-		return "", err
-	}
+	aead := e.aead
 
 	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
@@ -73,10 +77,7 @@ func (e *ChaCha20Encryptor) Decrypt(ciphertext string) (string, error) {
 		return "", err
 	}
 
-	aead, err := chacha20poly1305.New(e.key)
-	if err != nil {
-		return "", err
-	}
+	aead := e.aead
 
 	nonceSize := aead.NonceSize()
 	if len(data) < nonceSize {
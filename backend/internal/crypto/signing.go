@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+const SigningAlgorithm = "ed25519"
+
+// Signer 项目签名私钥，私钥只保存在服务端，客户端内置公钥验证响应
+type Signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+// NewSigner 由十六进制编码的32字节种子创建签名器
+func NewSigner(seed string) (*Signer, error) {
+	seedBytes, err := hex.DecodeString(seed)
+	if err != nil || len(seedBytes) != ed25519.SeedSize {
+		return nil, errors.New("无效的签名密钥")
+	}
+	return &Signer{privateKey: ed25519.NewKeyFromSeed(seedBytes)}, nil
+}
+
+// GenerateSigningKey 生成新的签名私钥种子（十六进制）
+func GenerateSigningKey() string {
+	seed := make([]byte, ed25519.SeedSize)
+	rand.Read(seed)
+	return hex.EncodeToString(seed)
+}
+
+// Sign 返回Base64编码的签名
+func (s *Signer) Sign(message []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, message))
+}
+
+// PrivateKey 供同一签名密钥签发离线授权等数据使用
+func (s *Signer) PrivateKey() ed25519.PrivateKey {
+	return s.privateKey
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.privateKey.Public().(ed25519.PublicKey)
+}
+
+// PublicKeyBase64 客户端内置的公钥
+func (s *Signer) PublicKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(s.PublicKey())
+}
+
+// Fingerprint 公钥的SHA-256（十六进制），便于人工核对
+func (s *Signer) Fingerprint() string {
+	sum := sha256.Sum256(s.PublicKey())
+	return hex.EncodeToString(sum[:])
+}
+
+// SigningPublicKeyOf 返回签名私钥对应的公钥（Base64），密钥无效时返回空字符串
+func SigningPublicKeyOf(seed string) string {
+	signer, err := NewSigner(seed)
+	if err != nil {
+		return ""
+	}
+	return signer.PublicKeyBase64()
+}
@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const SchemeX25519 = "x25519-chacha20-poly1305"
+
+// HKDF info，客户端需使用相同的值派生会话密钥
+const (
+	sessionInfoClientToServer = "nextkey session v1 c2s"
+	sessionInfoServerToClient = "nextkey session v1 s2c"
+)
+
+var ErrHandshakeRequired = errors.New("该加密方案需要先握手建立会话")
+
+func init() {
+	Register(EncryptorFactory{
+		Meta: EncryptorMeta{
+			Scheme:        SchemeX25519,
+			Name:          "X25519 + ChaCha20-Poly1305",
+			Description:   "客户端只内置服务端公钥，握手后每个会话使用独立密钥，单个客户端被逆向不影响其他客户端",
+			SecurityLevel: "secure",
+			Performance:   "fast",
+			IsDeprecated:  false,
+		},
+		NewEncryptor: func(key string) (Encryptor, error) {
+			return NewX25519Encryptor(key)
+		},
+		GenerateKey: generateX25519Key,
+	})
+}
+
+// KeyExchanger 基于密钥交换的方案，项目密钥为服务端静态私钥，实际加解密由会话完成
+type KeyExchanger interface {
+	Encryptor
+	PublicKey() []byte
+	NewSession(clientPublicKey []byte) (Encryptor, error)
+}
+
+// X25519Encryptor 持有服务端静态私钥，本身不能加解密业务数据
+type X25519Encryptor struct {
+	privateKey *ecdh.PrivateKey
+}
+
+func NewX25519Encryptor(key string) (*X25519Encryptor, error) {
+	// 私钥以十六进制保存，兼容Base64
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		if keyBytes, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, errors.New("无效的X25519私钥")
+		}
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, errors.New("X25519私钥必须为32字节")
+	}
+	return &X25519Encryptor{privateKey: privateKey}, nil
+}
+
+func (e *X25519Encryptor) Scheme() string {
+	return SchemeX25519
+}
+
+func generateX25519Key() string {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(privateKey.Bytes())
+}
+
+func (e *X25519Encryptor) Encrypt(plaintext string) (string, error) {
+	return "", ErrHandshakeRequired
+}
+
+func (e *X25519Encryptor) Decrypt(ciphertext string) (string, error) {
+	return "", ErrHandshakeRequired
+}
+
+func (e *X25519Encryptor) PublicKey() []byte {
+	return e.privateKey.PublicKey().Bytes()
+}
+
+// NewSession 与客户端临时公钥协商，按方向派生两把会话密钥
+// salt = 客户端公钥 || 服务端公钥
+func (e *X25519Encryptor) NewSession(clientPublicKey []byte) (Encryptor, error) {
+	peer, err := ecdh.X25519().NewPublicKey(clientPublicKey)
+	if err != nil {
+		return nil, errors.New("无效的客户端公钥")
+	}
+
+	shared, err := e.privateKey.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(append([]byte{}, clientPublicKey...), e.PublicKey()...)
+	recv, err := deriveSessionAEAD(shared, salt, sessionInfoClientToServer)
+	if err != nil {
+		return nil, err
+	}
+	send, err := deriveSessionAEAD(shared, salt, sessionInfoServerToClient)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionEncryptor{send: send, recv: recv}, nil
+}
+
+func deriveSessionAEAD(shared, salt []byte, info string) (cipher.AEAD, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// PublicKeyOf 返回密钥交换方案的服务端公钥（Base64），其他方案返回空字符串
+func PublicKeyOf(scheme, key string) string {
+	if scheme != SchemeX25519 {
+		return ""
+	}
+	encryptor, err := NewX25519Encryptor(key)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(encryptor.PublicKey())
+}
+
+// SessionEncryptor 握手后的会话加密器，解密用客户端到服务端的密钥，加密用服务端到客户端的密钥
+// 密文格式与 chacha20-poly1305 方案相同：Base64(nonce || 密文)
+type SessionEncryptor struct {
+	send cipher.AEAD
+	recv cipher.AEAD
+}
+
+func (e *SessionEncryptor) Scheme() string {
+	return SchemeX25519
+}
+
+func (e *SessionEncryptor) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, e.send.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := e.send.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (e *SessionEncryptor) Decrypt(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := e.recv.NonceSize()
+	if len(data) < nonceSize {
+		return "", errors.New("密文长度不足")
+	}
+
+	nonce, cipherData := data[:nonceSize], data[nonceSize:]
+	plaintext, err := e.recv.Open(nil, nonce, cipherData, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -54,6 +54,9 @@ func migrate() error {
 		log.Printf("Token表迁移警告: %v", err)
 	}
 
+	// 可见性字段加入前免费模式客户端能读取全部云变量，字段首次创建时需要迁移
+	needVisibility := DB.Migrator().HasTable(&models.CloudVar{}) && !DB.Migrator().HasColumn(&models.CloudVar{}, "visibility")
+
 	if err := DB.AutoMigrate(
 		&models.Admin{},
 		&models.AdminToken{},
@@ -62,8 +65,18 @@ func migrate() error {
 		&models.Card{},
 		&models.Token{},
 		&models.CloudVar{},
+		&models.CloudVarVersion{},
+		&models.CloudVarRevision{},
 		&models.Nonce{},
 		&models.UnbindRecord{},
+		&models.RateLimitBucket{},
+		&models.ProjectKey{},
+		&models.CryptoSession{},
+		&models.OfflineLicense{},
+		&models.CardTemplate{},
+		&models.UsageCounter{},
+		&models.Announcement{},
+		&models.AnnouncementRead{},
 	); err != nil {
 		return err
 	}
@@ -82,6 +95,20 @@ func migrate() error {
 		log.Printf("项目解绑字段迁移警告: %v", err)
 	}
 
+	if err := migrateProjectKeys(); err != nil {
+		log.Printf("项目密钥版本迁移警告: %v", err)
+	}
+
+	if err := migrateProjectSigningKeys(); err != nil {
+		log.Printf("项目签名密钥迁移警告: %v", err)
+	}
+
+	if needVisibility {
+		if err := migrateCloudVarVisibility(); err != nil {
+			log.Printf("云变量可见性迁移警告: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -331,6 +358,65 @@ func migrateProjectUnbindSlug() error {
 	return DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_unbind_slug ON projects(unbind_slug)").Error
 }
 
+// migrateProjectKeys 为尚无密钥版本记录的项目登记当前密钥为活动密钥
+func migrateProjectKeys() error {
+	var projects []models.Project
+	if err := DB.Where("id NOT IN (?)", DB.Model(&models.ProjectKey{}).Select("project_id")).Find(&projects).Error; err != nil {
+		return err
+	}
+
+	for _, p := range projects {
+		if p.KeyVersion <= 0 {
+			p.KeyVersion = 1
+			if err := DB.Model(&p).Update("key_version", 1).Error; err != nil {
+				return err
+			}
+		}
+		activatedAt := p.CreatedAt
+		key := models.ProjectKey{
+			ProjectID:   p.ID,
+			Version:     p.KeyVersion,
+			Scheme:      p.EncryptionScheme,
+			Key:         p.EncryptionKey,
+			Status:      models.KeyStatusActive,
+			ActivatedAt: &activatedAt,
+		}
+		if err := DB.Create(&key).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateProjectSigningKeys 为没有签名密钥的项目生成响应签名私钥
+func migrateProjectSigningKeys() error {
+	var projects []models.Project
+	if err := DB.Unscoped().Where("signing_key IS NULL OR signing_key = ''").Find(&projects).Error; err != nil {
+		return err
+	}
+
+	for i := range projects {
+		if err := DB.Unscoped().Model(&projects[i]).Update("signing_key", crypto.GenerateSigningKey()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCloudVarVisibility 免费模式项目的已有变量设为公开，其余保持默认的会话可见
+func migrateCloudVarVisibility() error {
+	result := DB.Model(&models.CloudVar{}).
+		Where("project_id IN (?)", DB.Model(&models.Project{}).Select("id").Where("mode = ?", "free")).
+		Update("visibility", models.VisibilityPublic)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("云变量可见性迁移完成: %d 个免费模式变量设为公开", result.RowsAffected)
+	}
+	return nil
+}
+
 func generateUniqueUnbindSlug() (string, error) {
 	for i := 0; i < 5; i++ {
 		slug := utils.RandomString(24, utils.CharsetTypeAlphanumeric)
@@ -361,5 +447,11 @@ func cleanExpiredNonces() {
 
 		// 清理过期的JWT黑名单记录
 		DB.Where("expire_at < ?", now).Delete(&models.AdminTokenBlacklist{})
+
+		// 清理已回满的限流桶
+		DB.Where("expire_at < ?", now.Unix()).Delete(&models.RateLimitBucket{})
+
+		// 清理过期的加密会话
+		DB.Where("expire_at < ?", now).Delete(&models.CryptoSession{})
 	}
 }
@@ -56,6 +56,13 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// 项目删除时会话已注销，这里兜底拦截遗留会话
+		if _, err := getProjectByID(token.ProjectID); err != nil {
+			utils.Error(c, 401, "项目不存在")
+			c.Abort()
+			return
+		}
+
 		c.Set("token", &token)
 		if token.CardID != nil {
 			c.Set("card_id", *token.CardID)
@@ -12,13 +12,17 @@ import (
 	"github.com/nextkey/nextkey/backend/internal/crypto"
 	"github.com/nextkey/nextkey/backend/internal/database"
 	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/internal/store"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
 )
 
 type EncryptedRequest struct {
-	Timestamp int64  `json:"timestamp"`
-	Nonce     string `json:"nonce"`
-	Data      string `json:"data"`
+	Timestamp   int64  `json:"timestamp"`
+	Nonce       string `json:"nonce"`
+	ProjectUUID string `json:"project_uuid,omitempty"` // 明文项目标识，未登录请求据此直接选择项目密钥
+	KeyVersion  int    `json:"key_version,omitempty"`  // 加密所用的密钥版本，为空时依次尝试活动密钥和宽限期内的旧密钥
+	SessionID   string `json:"session_id,omitempty"`   // 密钥交换方案握手获得的会话ID，填写时使用会话密钥
+	Data        string `json:"data"`
 }
 
 type InternalRequest struct {
@@ -29,12 +33,19 @@ type InternalRequest struct {
 
 var replayWindowSeconds int64 = 300
 
+var trialDecryptEnabled = true
+
 func SetReplayWindow(seconds int) {
 	if seconds > 0 {
 		replayWindowSeconds = int64(seconds)
 	}
 }
 
+// SetTrialDecrypt 设置是否允许对未指明项目的请求逐个尝试项目密钥（兼容旧版客户端）
+func SetTrialDecrypt(enabled bool) {
+	trialDecryptEnabled = enabled
+}
+
 func DecryptMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		body, err := io.ReadAll(c.Request.Body)
@@ -51,28 +62,60 @@ func DecryptMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 获取项目级加密器
-		encryptor, project, err := getEncryptorFromRequest(c, encReq.Data)
+		var project *models.Project
+		var candidates []keyCandidate
+		if encReq.SessionID != "" {
+			project, candidates, err = getSessionFromRequest(c, &encReq)
+		} else {
+			project, err = getProjectFromRequest(c, &encReq)
+			if err == nil {
+				candidates, err = projectKeyCandidates(project.ID, encReq.KeyVersion)
+			}
+		}
 		if err != nil {
 			utils.Error(c, 401, "认证失败")
 			c.Abort()
 			return
 		}
 
-		c.Set("request_nonce", encReq.Nonce)
-		c.Set("encryptor", encryptor)
-
-		plaintext, err := encryptor.Decrypt(encReq.Data)
-		if err != nil {
-			utils.EncryptedError(c, 400, "解密失败")
+		// 密钥交换方案的项目密钥不能直接加解密，未握手的请求只能使用对称密钥
+		candidates = symmetricCandidates(candidates)
+		if len(candidates) == 0 {
+			utils.Error(c, 401, crypto.ErrHandshakeRequired.Error())
 			c.Abort()
 			return
 		}
 
-		// 解密后验证内层nonce和timestamp
+		c.Set("request_nonce", encReq.Nonce)
+		c.Set("encryptor", candidates[0].encryptor)
+		if signer, err := GetProjectSigner(project.ID); err == nil {
+			c.Set("signer", signer)
+		}
+
+		// 轮换期间依次尝试可用密钥，响应使用请求所用的密钥加密
 		var internalReq InternalRequest
-		if err := json.Unmarshal([]byte(plaintext), &internalReq); err != nil {
-			utils.EncryptedError(c, 400, "内部数据格式错误")
+		var decryptErr string
+		for i, candidate := range candidates {
+			plaintext, err := candidate.encryptor.Decrypt(encReq.Data)
+			if err != nil {
+				if i == 0 {
+					decryptErr = "解密失败"
+				}
+				continue
+			}
+			if err := json.Unmarshal([]byte(plaintext), &internalReq); err != nil {
+				if i == 0 {
+					decryptErr = "内部数据格式错误"
+				}
+				continue
+			}
+			decryptErr = ""
+			c.Set("encryptor", candidate.encryptor)
+			c.Set("key_version", candidate.version)
+			break
+		}
+		if decryptErr != "" {
+			utils.EncryptedError(c, 400, decryptErr)
 			c.Abort()
 			return
 		}
@@ -99,58 +142,98 @@ func DecryptMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 使用数据库唯一约束防止重放攻击和竞态条件
-		nonce := models.Nonce{Nonce: encReq.Nonce}
-		if err := database.DB.Create(&nonce).Error; err != nil {
-			if database.IsDuplicateError(err) {
-				utils.EncryptedError(c, 401, "检测到重放攻击")
-				c.Abort()
-				return
-			}
+		fresh, err := nonceStore.Remember(encReq.Nonce, store.NonceRetention(int(replayWindowSeconds)))
+		if err != nil {
 			log.Printf("nonce写入失败 ip=%s err=%v", c.ClientIP(), err)
 			utils.EncryptedError(c, 503, "服务暂时不可用")
 			c.Abort()
 			return
 		}
+		if !fresh {
+			utils.EncryptedError(c, 401, "检测到重放攻击")
+			c.Abort()
+			return
+		}
 
 		c.Set("decrypted_data", string(internalReq.Data))
 		c.Set("project_id", project.ID)
+		c.Set("project_uuid", project.UUID)
 		c.Next()
 	}
 }
 
-func getEncryptorFromRequest(c *gin.Context, encryptedData string) (crypto.Encryptor, *models.Project, error) {
+func symmetricCandidates(candidates []keyCandidate) []keyCandidate {
+	result := candidates[:0:0]
+	for _, candidate := range candidates {
+		if _, ok := candidate.encryptor.(crypto.KeyExchanger); !ok {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+// getSessionFromRequest 按会话ID确定项目和会话密钥，已登录请求的Token必须属于同一项目
+func getSessionFromRequest(c *gin.Context, encReq *EncryptedRequest) (*models.Project, []keyCandidate, error) {
+	session, err := getCryptoSession(encReq.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if projectID, exists := c.Get("project_id"); exists && projectID.(uint) != session.projectID {
+		return nil, nil, errors.New("会话与Token不属于同一项目")
+	}
+
+	project, err := getProjectByID(session.projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if encReq.ProjectUUID != "" && encReq.ProjectUUID != project.UUID {
+		return nil, nil, errors.New("会话与项目不匹配")
+	}
+	return project, []keyCandidate{{version: session.keyVersion, encryptor: session.encryptor}}, nil
+}
+
+// getProjectFromRequest 确定请求所属项目
+func getProjectFromRequest(c *gin.Context, encReq *EncryptedRequest) (*models.Project, error) {
+	// AuthMiddleware 已加载Token时直接使用其项目，避免重复查询
+	if projectID, exists := c.Get("project_id"); exists {
+		return getProjectByID(projectID.(uint))
+	}
+
 	// 尝试从token中获取project_id
 	token := c.GetHeader("Authorization")
 	if token != "" && len(token) > 7 && token[:7] == "Bearer " {
 		tokenStr := token[7:]
 		var tokenModel models.Token
 		if err := database.DB.Where("token = ?", tokenStr).First(&tokenModel).Error; err == nil {
-			var project models.Project
-			if err := database.DB.Where("id = ?", tokenModel.ProjectID).First(&project).Error; err == nil {
-				encryptor, err := crypto.NewEncryptor(project.EncryptionScheme, project.EncryptionKey)
-				if err != nil {
-					return nil, nil, err
-				}
-				return encryptor, &project, nil
+			if project, err := getProjectByID(tokenModel.ProjectID); err == nil {
+				return project, nil
 			}
 		}
 	}
 
-	// 尝试通过解析加密数据中的project_uuid获取项目
-	// 这需要尝试所有项目的密钥（用于登录等首次请求）
+	// 外层指明了项目时直接使用该项目的密钥，不再回退到逐个尝试
+	if encReq.ProjectUUID != "" {
+		return getProjectByUUID(encReq.ProjectUUID)
+	}
+
+	if !trialDecryptEnabled {
+		return nil, errors.New("请求未指明项目")
+	}
+
+	// 兼容旧版客户端：尝试通过解析加密数据中的project_uuid获取项目
+	// 这需要尝试所有项目的密钥，开销随项目数量增长
 	var projects []models.Project
 	if err := database.DB.Find(&projects).Error; err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	for _, project := range projects {
-		encryptor, err := crypto.NewEncryptor(project.EncryptionScheme, project.EncryptionKey)
+		encryptor, err := projectEncryptor(&project)
 		if err != nil {
 			continue
 		}
 
-		plaintext, err := encryptor.Decrypt(encryptedData)
+		plaintext, err := encryptor.Decrypt(encReq.Data)
 		if err != nil {
 			continue
 		}
@@ -168,11 +251,11 @@ func getEncryptorFromRequest(c *gin.Context, encryptedData string) (crypto.Encry
 
 		projectUUID, ok := data["project_uuid"].(string)
 		if ok && projectUUID == project.UUID {
-			return encryptor, &project, nil
+			return &project, nil
 		}
 	}
 
-	return nil, nil, errors.New("无法找到匹配的项目加密配置")
+	return nil, errors.New("无法找到匹配的项目加密配置")
 }
 
 func GetDecryptedData(c *gin.Context, v interface{}) error {
@@ -188,3 +271,13 @@ func GetDecryptedData(c *gin.Context, v interface{}) error {
 
 	return json.Unmarshal([]byte(plaintext), v)
 }
+
+// MatchProjectUUID 校验业务参数中的project_uuid与解密所用的项目一致，为空时填充为该项目
+func MatchProjectUUID(c *gin.Context, projectUUID *string) bool {
+	decryptedUUID := c.GetString("project_uuid")
+	if *projectUUID == "" {
+		*projectUUID = decryptedUUID
+		return true
+	}
+	return *projectUUID == decryptedUUID
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/crypto"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+// 本实例内的修改通过 InvalidateProjectCache 立即生效
+// 有效期用于多实例部署时兜底，其他实例的修改最多延迟该时长生效
+const projectCacheTTL = 5 * time.Minute
+
+type projectCacheEntry struct {
+	project  models.Project
+	keys     []models.ProjectKey // 未停用的密钥版本
+	signer   *crypto.Signer      // 响应签名，项目缺少签名密钥时为空
+	expireAt time.Time
+}
+
+var projectCache = struct {
+	sync.RWMutex
+	byID   map[uint]*projectCacheEntry
+	byUUID map[string]uint
+}{
+	byID:   make(map[uint]*projectCacheEntry),
+	byUUID: make(map[string]uint),
+}
+
+// InvalidateProjectCache 清除项目元数据和加密器缓存，项目被修改或删除后调用
+func InvalidateProjectCache(projectID uint) {
+	projectCache.Lock()
+	if entry, exists := projectCache.byID[projectID]; exists {
+		delete(projectCache.byUUID, entry.project.UUID)
+		delete(projectCache.byID, projectID)
+	}
+	projectCache.Unlock()
+
+	crypto.InvalidateProjectEncryptors(projectID)
+	invalidateProjectSessions(projectID)
+}
+
+// getProjectByID 获取项目，只缓存数据库中存在的项目
+func getProjectByID(id uint) (*models.Project, error) {
+	entry, err := getProjectEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	project := entry.project
+	return &project, nil
+}
+
+// getProjectByUUID 按UUID获取项目，只缓存数据库中存在的项目
+func getProjectByUUID(uuid string) (*models.Project, error) {
+	projectCache.RLock()
+	id, exists := projectCache.byUUID[uuid]
+	projectCache.RUnlock()
+
+	if exists {
+		return getProjectByID(id)
+	}
+
+	var project models.Project
+	if err := database.DB.Where("uuid = ?", uuid).First(&project).Error; err != nil {
+		return nil, err
+	}
+	entry, err := cacheProject(&project)
+	if err != nil {
+		return nil, err
+	}
+	result := entry.project
+	return &result, nil
+}
+
+func getProjectEntry(id uint) (*projectCacheEntry, error) {
+	projectCache.RLock()
+	entry, exists := projectCache.byID[id]
+	projectCache.RUnlock()
+
+	if exists && time.Now().Before(entry.expireAt) {
+		return entry, nil
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, id).Error; err != nil {
+		return nil, err
+	}
+	return cacheProject(&project)
+}
+
+func cacheProject(project *models.Project) (*projectCacheEntry, error) {
+	var keys []models.ProjectKey
+	if err := database.DB.Where("project_id = ? AND status <> ?", project.ID, models.KeyStatusRetired).
+		Order("version DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	entry := &projectCacheEntry{
+		project:  *project,
+		keys:     keys,
+		expireAt: time.Now().Add(projectCacheTTL),
+	}
+	if signer, err := crypto.NewSigner(project.SigningKey); err == nil {
+		entry.signer = signer
+	}
+
+	projectCache.Lock()
+	defer projectCache.Unlock()
+
+	projectCache.byID[project.ID] = entry
+	projectCache.byUUID[project.UUID] = project.ID
+	return entry, nil
+}
+
+// GetProjectSigner 获取项目响应签名器
+func GetProjectSigner(projectID uint) (*crypto.Signer, error) {
+	entry, err := getProjectEntry(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.signer == nil {
+		return nil, errors.New("项目未配置签名密钥")
+	}
+	return entry.signer, nil
+}
+
+func projectEncryptor(project *models.Project) (crypto.Encryptor, error) {
+	return crypto.GetProjectEncryptor(project.ID, project.KeyVersion, project.EncryptionScheme, project.EncryptionKey)
+}
+
+type keyCandidate struct {
+	version   int
+	encryptor crypto.Encryptor
+}
+
+// projectKeyCandidates 返回可用于解密请求的密钥，按尝试顺序排列
+// 指定版本时只返回该版本；未指定时先活动密钥，再宽限期内的旧密钥
+func projectKeyCandidates(projectID uint, version int) ([]keyCandidate, error) {
+	entry, err := getProjectEntry(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var keys []models.ProjectKey
+	for _, key := range entry.keys {
+		if !key.Accepts(now) {
+			continue
+		}
+		if version > 0 {
+			if key.Version == version {
+				keys = append(keys, key)
+			}
+			continue
+		}
+		switch key.Status {
+		case models.KeyStatusActive:
+			keys = append([]models.ProjectKey{key}, keys...)
+		case models.KeyStatusRetiring:
+			keys = append(keys, key)
+		}
+	}
+
+	// 没有密钥版本记录时使用项目上的密钥
+	if len(entry.keys) == 0 && (version <= 0 || version == entry.project.KeyVersion) {
+		encryptor, err := projectEncryptor(&entry.project)
+		if err != nil {
+			return nil, err
+		}
+		return []keyCandidate{{version: entry.project.KeyVersion, encryptor: encryptor}}, nil
+	}
+
+	candidates := make([]keyCandidate, 0, len(keys))
+	for _, key := range keys {
+		encryptor, err := crypto.GetProjectEncryptor(projectID, key.Version, key.Scheme, key.Key)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, keyCandidate{version: key.Version, encryptor: encryptor})
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("没有可用的项目密钥")
+	}
+	return candidates, nil
+}
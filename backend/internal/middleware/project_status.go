@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+// ProjectStatusMiddleware 项目维护或停用时返回加密的状态和提示，需放在 DecryptMiddleware 之后
+// 维护白名单按当前会话的卡密和设备码匹配，未登录的请求只在项目正常时放行
+func ProjectStatusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID, exists := c.Get("project_id")
+		if !exists {
+			c.Next()
+			return
+		}
+		project, err := getProjectByID(projectID.(uint))
+		if err != nil {
+			utils.EncryptedError(c, 404, "项目不存在")
+			c.Abort()
+			return
+		}
+		if project.Status == "" || project.Status == models.ProjectStatusActive {
+			c.Next()
+			return
+		}
+
+		var cardKey, hwid string
+		if val, ok := c.Get("token"); ok {
+			token := val.(*models.Token)
+			hwid = token.HWID
+			if token.CardID != nil && len(project.StatusWhitelist) > 0 {
+				var card models.Card
+				if err := database.DB.Select("card_key").First(&card, *token.CardID).Error; err == nil {
+					cardKey = card.CardKey
+				}
+			}
+		}
+		if !project.Allows(cardKey, hwid) {
+			info := project.StatusInfo()
+			utils.EncryptedErrorWithData(c, project.StatusCode(), info.Message, info)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,104 +1,88 @@
 package middleware
 
 import (
-	"sync"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nextkey/nextkey/backend/internal/store"
+	"github.com/nextkey/nextkey/backend/pkg/config"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
 )
 
-type rateLimiter struct {
-	requests map[string][]time.Time
-	mu       sync.RWMutex
-	limit    int
-	window   time.Duration
-}
-
-func newRateLimiter(limit int, window time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
-	}
-
-	// 定期清理过期记录
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			rl.cleanup()
-		}
-	}()
-
-	return rl
-}
+const (
+	RateLimitKeyIP      = "ip"
+	RateLimitKeyCard    = "card"
+	RateLimitKeyToken   = "token"
+	RateLimitKeyProject = "project"
+)
 
-func (rl *rateLimiter) cleanup() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+var (
+	defaultStore                     = store.NewMemoryStore(store.NonceRetention(0))
+	rateLimitRules                   = map[string]config.RateLimitRule{}
+	rateLimitStore store.RateLimiter = defaultStore
+	nonceStore     store.NonceStore  = defaultStore
+)
 
-	now := time.Now()
-	for ip, timestamps := range rl.requests {
-		var valid []time.Time
-		for _, t := range timestamps {
-			if now.Sub(t) < rl.window {
-				valid = append(valid, t)
-			}
-		}
-		if len(valid) == 0 {
-			delete(rl.requests, ip)
-		} else {
-			rl.requests[ip] = valid
-		}
+// SetRateLimitRules 设置各路由分组的限流规则
+func SetRateLimitRules(rules map[string]config.RateLimitRule) {
+	if rules != nil {
+		rateLimitRules = rules
 	}
 }
 
-func (rl *rateLimiter) allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-
-	// 获取该IP的请求记录
-	timestamps, exists := rl.requests[ip]
-	if !exists {
-		rl.requests[ip] = []time.Time{now}
-		return true
-	}
-
-	// 过滤掉窗口外的请求
-	var validTimestamps []time.Time
-	for _, t := range timestamps {
-		if now.Sub(t) < rl.window {
-			validTimestamps = append(validTimestamps, t)
-		}
+// SetRateLimitStore 设置限流存储后端
+func SetRateLimitStore(s store.RateLimiter) {
+	if s != nil {
+		rateLimitStore = s
 	}
+}
 
-	// 检查是否超过限制
-	if len(validTimestamps) >= rl.limit {
-		return false
+// SetNonceStore 设置防重放nonce存储后端
+func SetNonceStore(s store.NonceStore) {
+	if s != nil {
+		nonceStore = s
 	}
-
-	// 添加新请求
-	validTimestamps = append(validTimestamps, now)
-	rl.requests[ip] = validTimestamps
-
-	return true
 }
 
-var loginLimiter = newRateLimiter(5, 1*time.Minute)
-
-// RateLimitMiddleware 速率限制中间件
-func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
-	limiter := newRateLimiter(limit, window)
-
+// RateLimitMiddleware 按路由分组限流，规则来自配置文件的 rate_limit.rules
+// 以 card/token/project 为维度的规则需要放在 AuthMiddleware 之后，取不到时退化为按IP限流
+func RateLimitMiddleware(group string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
+		rule, exists := rateLimitRules[group]
+		if !exists || rule.Limit <= 0 || rule.Window <= 0 {
+			c.Next()
+			return
+		}
 
-		if !limiter.allow(ip) {
-			utils.Error(c, 429, "请求过于频繁，请稍后再试")
-			c.Abort()
+		key := group + ":" + rateLimitKey(c, rule.Key)
+		result, err := rateLimitStore.Take(key, store.Bucket{
+			Limit:  rule.Limit,
+			Window: time.Duration(rule.Window) * time.Second,
+			Burst:  rule.Burst,
+		})
+		if err != nil {
+			// 存储不可用时放行，避免限流后端故障导致整体不可用
+			log.Printf("限流存储访问失败 group=%s err=%v", group, err)
+			c.Next()
+			return
+		}
+
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(429, utils.Response{
+				Code:    429,
+				Message: "请求过于频繁，请" + strconv.Itoa(retryAfter) + "秒后再试",
+			})
 			return
 		}
 
@@ -106,17 +90,23 @@ func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
 	}
 }
 
-// LoginRateLimitMiddleware 登录专用速率限制中间件
-func LoginRateLimitMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ip := c.ClientIP()
-
-		if !loginLimiter.allow(ip) {
-			utils.Error(c, 429, "登录尝试过于频繁，请1分钟后再试")
-			c.Abort()
-			return
+func rateLimitKey(c *gin.Context, keyType string) string {
+	switch keyType {
+	case RateLimitKeyCard:
+		if cardID, exists := c.Get("card_id"); exists {
+			return "card:" + strconv.FormatUint(uint64(cardID.(uint)), 10)
+		}
+	case RateLimitKeyToken:
+		authHeader := c.GetHeader("Authorization")
+		if tokenStr, ok := strings.CutPrefix(authHeader, "Bearer "); ok && tokenStr != "" {
+			// 令牌会写入数据库/Redis 存储，只保存摘要
+			sum := sha256.Sum256([]byte(tokenStr))
+			return "token:" + hex.EncodeToString(sum[:])
+		}
+	case RateLimitKeyProject:
+		if projectID, exists := c.Get("project_id"); exists {
+			return "project:" + strconv.FormatUint(uint64(projectID.(uint)), 10)
 		}
-
-		c.Next()
 	}
+	return "ip:" + c.ClientIP()
 }
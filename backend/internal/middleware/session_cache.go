@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/crypto"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+const sessionCacheSweepInterval = time.Minute
+
+type sessionCacheEntry struct {
+	projectID  uint
+	keyVersion int
+	encryptor  crypto.Encryptor
+	expireAt   time.Time
+}
+
+var sessionCache = struct {
+	sync.RWMutex
+	entries   map[string]*sessionCacheEntry
+	lastSweep time.Time
+}{
+	entries: make(map[string]*sessionCacheEntry),
+}
+
+// getCryptoSession 获取握手会话，首次使用时由服务端私钥重新派生会话密钥
+func getCryptoSession(sessionID string) (*sessionCacheEntry, error) {
+	now := time.Now()
+
+	sessionCache.RLock()
+	entry, exists := sessionCache.entries[sessionID]
+	sessionCache.RUnlock()
+
+	if exists {
+		if now.Before(entry.expireAt) {
+			return entry, nil
+		}
+		return nil, errors.New("会话已过期")
+	}
+
+	var session models.CryptoSession
+	if err := database.DB.Where("session_id = ? AND expire_at > ?", sessionID, now).First(&session).Error; err != nil {
+		return nil, errors.New("会话不存在或已过期")
+	}
+
+	// 会话所用的服务端密钥停用后会话随之失效
+	candidates, err := projectKeyCandidates(session.ProjectID, session.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	exchanger, ok := candidates[0].encryptor.(crypto.KeyExchanger)
+	if !ok {
+		return nil, errors.New("项目未使用密钥交换加密方案")
+	}
+
+	clientPublicKey, err := base64.StdEncoding.DecodeString(session.ClientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := exchanger.NewSession(clientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &sessionCacheEntry{
+		projectID:  session.ProjectID,
+		keyVersion: session.KeyVersion,
+		encryptor:  encryptor,
+		expireAt:   session.ExpireAt,
+	}
+
+	sessionCache.Lock()
+	sessionCache.entries[sessionID] = entry
+	if now.Sub(sessionCache.lastSweep) > sessionCacheSweepInterval {
+		for id, e := range sessionCache.entries {
+			if !now.Before(e.expireAt) {
+				delete(sessionCache.entries, id)
+			}
+		}
+		sessionCache.lastSweep = now
+	}
+	sessionCache.Unlock()
+
+	return entry, nil
+}
+
+// invalidateProjectSessions 清除项目的会话缓存，密钥变更后下次使用时重新校验
+func invalidateProjectSessions(projectID uint) {
+	sessionCache.Lock()
+	defer sessionCache.Unlock()
+
+	for id, entry := range sessionCache.entries {
+		if entry.projectID == projectID {
+			delete(sessionCache.entries, id)
+		}
+	}
+}
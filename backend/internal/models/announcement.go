@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Announcement 卡密为空时为项目公告，否则为发给该卡密的消息
+type Announcement struct {
+	ID         uint           `gorm:"primarykey" json:"id"`
+	ProjectID  uint           `gorm:"not null;index" json:"project_id"`
+	CardID     *uint          `gorm:"index" json:"card_id"`
+	Title      string         `gorm:"not null" json:"title"`
+	Content    string         `gorm:"type:text" json:"content"`
+	Priority   int            `gorm:"default:0" json:"priority"`            // 越大越靠前
+	StartAt    *time.Time     `json:"start_at"`                             // 为空时立即生效
+	EndAt      *time.Time     `json:"end_at"`                               // 为空时一直有效
+	Templates  UintArray      `gorm:"type:text" json:"templates,omitempty"` // 只推送给引用这些模板的卡密
+	MinVersion string         `json:"min_version"`                          // 客户端版本下限(含)
+	MaxVersion string         `json:"max_version"`                          // 客户端版本上限(含)
+	CreatedBy  uint           `json:"created_by"`
+	CardKey    string         `gorm:"-" json:"card_key,omitempty"`   // 管理端列表填充
+	ReadCount  int64          `gorm:"-" json:"read_count,omitempty"` // 管理端列表填充
+	Read       bool           `gorm:"-" json:"read"`                 // 返回给客户端时填充
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Active 是否处于生效时间内
+func (a *Announcement) Active(now time.Time) bool {
+	if a.StartAt != nil && now.Before(*a.StartAt) {
+		return false
+	}
+	if a.EndAt != nil && !now.Before(*a.EndAt) {
+		return false
+	}
+	return true
+}
+
+// Matches 按模板和客户端版本判断是否推送，条件与云变量灰度规则一致
+func (a *Announcement) Matches(subject *RolloutSubject) bool {
+	if a.CardID != nil && (subject.CardID == 0 || *a.CardID != subject.CardID) {
+		return false
+	}
+	rule := RolloutRule{Templates: a.Templates, MinVersion: a.MinVersion, MaxVersion: a.MaxVersion}
+	return rule.Matches("", subject)
+}
+
+// AnnouncementRead 客户端确认已读的记录，付费模式按卡密，免费模式按设备码
+type AnnouncementRead struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	AnnouncementID uint      `gorm:"not null;uniqueIndex:idx_announcement_reader" json:"announcement_id"`
+	CardID         uint      `gorm:"not null;default:0;uniqueIndex:idx_announcement_reader" json:"card_id"`
+	HWID           string    `gorm:"not null;default:'';uniqueIndex:idx_announcement_reader" json:"hwid"`
+	ReadAt         time.Time `json:"read_at"`
+}
@@ -49,11 +49,18 @@ type Card struct {
 	ExpireAt    *time.Time     `json:"expire_at"`
 	Note        string         `json:"note"`
 	CardType    string         `gorm:"default:normal" json:"card_type"`
-	CustomData  string         `gorm:"type:text" json:"custom_data"` // JSON
+	CustomData  string         `gorm:"type:text" json:"custom_data"`         // JSON，客户端可写
+	AdminData   string         `gorm:"type:text" json:"admin_data"`          // 管理员专属信息，客户端只读
+	DataVersion int            `gorm:"default:0" json:"custom_data_version"` // custom_data 每次写入递增，用于乐观并发控制
 	HWIDList    StringArray    `gorm:"type:text" json:"hwid_list"`
 	IPList      StringArray    `gorm:"type:text" json:"ip_list"`
 	MaxHWID     int            `gorm:"default:-1" json:"max_hwid"` // -1 无限制
 	MaxIP       int            `gorm:"default:-1" json:"max_ip"`   // -1 无限制
+	TemplateID  *uint          `gorm:"index" json:"template_id"`
+	Template    *CardTemplate  `gorm:"foreignKey:TemplateID" json:"template,omitempty"`
+	Features    StringArray    `gorm:"type:text" json:"features"` // 在模板基础上额外开通的功能
+	Quotas      QuotaMap       `gorm:"type:text" json:"quotas"`   // 覆盖模板的同名配额
+	Meters      MeterLimits    `gorm:"type:text" json:"meters"`   // 覆盖模板的同名计量项
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
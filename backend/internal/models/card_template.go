@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CardTemplate 卡密模板，生成卡密时提供默认参数，模板上的权益对引用它的卡密实时生效
+type CardTemplate struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	ProjectID uint           `gorm:"not null;index" json:"project_id"`
+	Name      string         `gorm:"not null" json:"name"`
+	Duration  int            `gorm:"default:0" json:"duration"` // 秒
+	CardType  string         `json:"card_type"`
+	MaxHWID   int            `gorm:"default:-1" json:"max_hwid"`
+	MaxIP     int            `gorm:"default:-1" json:"max_ip"`
+	Features  StringArray    `gorm:"type:text" json:"features"`
+	Quotas    QuotaMap       `gorm:"type:text" json:"quotas"`
+	Meters    MeterLimits    `gorm:"type:text" json:"meters"` // 用量计量配额
+	Note      string         `json:"note"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
@@ -1,18 +1,178 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// 云变量可见性
+const (
+	VisibilityPublic   = "public"   // 登录前即可读取
+	VisibilitySession  = "session"  // 任意付费模式会话
+	VisibilityEntitled = "entitled" // 指定模板或拥有指定功能的卡密
+	VisibilityAdmin    = "admin"    // 仅管理后台可见
+)
+
+// 云变量类型，json 类型可附带 JSON Schema
+const (
+	VarTypeString = "string"
+	VarTypeInt    = "int"
+	VarTypeFloat  = "float"
+	VarTypeBool   = "bool"
+	VarTypeJSON   = "json"
+)
+
+func ValidVarType(t string) bool {
+	return t == VarTypeString || t == VarTypeInt || t == VarTypeFloat || t == VarTypeBool || t == VarTypeJSON
+}
+
+// ParseVarValue 按类型解析变量值，json 类型返回原始JSON
+func ParseVarValue(typ, value string) (interface{}, error) {
+	switch typ {
+	case VarTypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("不是合法的整数")
+		}
+		return n, nil
+	case VarTypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.New("不是合法的数字")
+		}
+		return f, nil
+	case VarTypeBool:
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, errors.New("布尔值只能是 true 或 false")
+	case VarTypeJSON:
+		if !json.Valid([]byte(value)) {
+			return nil, errors.New("不是合法的JSON")
+		}
+		return json.RawMessage(value), nil
+	}
+	return value, nil
+}
+
+func ValidVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilitySession || v == VisibilityEntitled || v == VisibilityAdmin
+}
+
+type UintArray []uint
+
+func (a UintArray) Value() (driver.Value, error) {
+	if a == nil {
+		return "[]", nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *UintArray) Scan(value interface{}) error {
+	*a = UintArray{}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	}
+	return nil
+}
+
+// CloudVar 模板和卡密均为空时为项目级变量
 type CloudVar struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	ProjectID uint           `gorm:"not null;index:idx_project_key" json:"project_id"`
-	Project   *Project       `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
-	Key       string         `gorm:"not null;index:idx_project_key" json:"key"`
-	Value     string         `gorm:"type:text" json:"value"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             uint           `gorm:"primarykey" json:"id"`
+	ProjectID      uint           `gorm:"not null;index:idx_project_key" json:"project_id"`
+	Project        *Project       `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+	TemplateID     *uint          `gorm:"index" json:"template_id"` // 模板级变量，对引用该模板的卡密生效
+	CardID         *uint          `gorm:"index" json:"card_id"`     // 卡密级变量，由客户端或管理员写入
+	Key            string         `gorm:"not null;index:idx_project_key" json:"key"`
+	Value          string         `gorm:"type:text" json:"value"`
+	Type           string         `gorm:"default:string" json:"type"`
+	Schema         string         `gorm:"type:text" json:"schema"` // json 类型可选的 JSON Schema
+	TypedValue     interface{}    `gorm:"-" json:"typed_value"`    // 按类型解析后的值，返回给客户端时填充
+	Visibility     string         `gorm:"default:session" json:"visibility"`
+	Version        int            `gorm:"default:0" json:"version"`         // 每次写入加一，历史见 CloudVarVersion
+	Revision       int64          `gorm:"default:0;index" json:"revision"`  // 写入或删除时取得的项目修订号，客户端据此增量同步
+	AllowTemplates UintArray      `gorm:"type:text" json:"allow_templates"` // entitled 可见性下允许的模板
+	AllowFeatures  StringArray    `gorm:"type:text" json:"allow_features"`  // entitled 可见性下拥有其一即可读取的功能
+	Rules          RolloutRules   `gorm:"type:text" json:"rules,omitempty"` // 灰度规则，按顺序取第一个命中的值，返回给客户端前移除
+	Segment        string         `gorm:"-" json:"segment,omitempty"`       // 客户端命中的规则名，有规则但未命中时为 default
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Scope 返回变量作用域: project/template/card
+func (v *CloudVar) Scope() string {
+	switch {
+	case v.CardID != nil:
+		return "card"
+	case v.TemplateID != nil:
+		return "template"
+	}
+	return "project"
+}
+
+// FillTypedValue 填充 TypedValue，值与类型不符时(如类型加入前的旧数据)按字符串返回
+func (v *CloudVar) FillTypedValue() {
+	typed, err := ParseVarValue(v.Type, v.Value)
+	if err != nil {
+		typed = v.Value
+	}
+	v.TypedValue = typed
+}
+
+// VisibleTo 判断客户端能否读取该变量，card 为空表示未登录或免费模式，只能看到公开变量
+func (v *CloudVar) VisibleTo(card *Card, features []string) bool {
+	switch v.Visibility {
+	case VisibilityPublic:
+		return true
+	case VisibilitySession, "":
+		return card != nil
+	case VisibilityEntitled:
+		if card == nil {
+			return false
+		}
+		if card.TemplateID != nil && slices.Contains(v.AllowTemplates, *card.TemplateID) {
+			return true
+		}
+		return slices.ContainsFunc(v.AllowFeatures, func(f string) bool { return slices.Contains(features, f) })
+	}
+	return false
+}
+
+// CloudVarVersion 云变量每次写入后的快照，用于查看历史和回滚
+type CloudVarVersion struct {
+	ID             uint         `gorm:"primarykey" json:"id"`
+	CloudVarID     uint         `gorm:"not null;index:idx_cloud_var_version" json:"cloud_var_id"`
+	Version        int          `gorm:"not null;index:idx_cloud_var_version" json:"version"`
+	Revision       int64        `gorm:"default:0" json:"revision"` // 写入时的项目修订号，用于判断变更前客户端是否可见
+	Value          string       `gorm:"type:text" json:"value"`
+	Type           string       `json:"type"`
+	Schema         string       `gorm:"type:text" json:"schema"`
+	Visibility     string       `json:"visibility"`
+	AllowTemplates UintArray    `gorm:"type:text" json:"allow_templates"`
+	AllowFeatures  StringArray  `gorm:"type:text" json:"allow_features"`
+	Rules          RolloutRules `gorm:"type:text" json:"rules"`
+	AdminID        *uint        `json:"admin_id"` // 客户端写入时为空
+	Author         string       `json:"author"`   // 管理员用户名，客户端写入为 client
+	Note           string       `json:"note"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// CloudVarRevision 项目云变量的修订号，任一变量写入或删除时递增
+type CloudVarRevision struct {
+	ProjectID uint  `gorm:"primarykey;autoIncrement:false" json:"project_id"`
+	Revision  int64 `gorm:"not null;default:0" json:"revision"`
 }
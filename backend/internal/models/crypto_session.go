@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// CryptoSession 密钥交换握手建立的会话
+// 只保存客户端临时公钥，会话密钥由服务端私钥重新派生，不落库
+type CryptoSession struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	SessionID       string    `gorm:"uniqueIndex;not null" json:"session_id"`
+	ProjectID       uint      `gorm:"index;not null" json:"project_id"`
+	KeyVersion      int       `gorm:"not null" json:"key_version"`
+	ClientPublicKey string    `gorm:"not null" json:"client_public_key"` // Base64
+	ExpireAt        time.Time `gorm:"index" json:"expire_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
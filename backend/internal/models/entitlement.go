@@ -0,0 +1,70 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"slices"
+)
+
+// QuotaMap 数值配额，键为配额名称
+type QuotaMap map[string]int64
+
+func (q QuotaMap) Value() (driver.Value, error) {
+	if q == nil {
+		return "{}", nil
+	}
+	return json.Marshal(q)
+}
+
+func (q *QuotaMap) Scan(value interface{}) error {
+	*q = QuotaMap{}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, q)
+	case string:
+		return json.Unmarshal([]byte(v), q)
+	}
+	return nil
+}
+
+// Entitlements 卡密最终生效的功能开关和配额，由管理员在模板和卡密上设置，客户端只读
+type Entitlements struct {
+	Features []string    `json:"features"`
+	Quotas   QuotaMap    `json:"quotas"`
+	Meters   MeterLimits `json:"meters"`
+}
+
+// ResolveEntitlements 合并模板与卡密的权益：功能取并集，同名配额和计量项以卡密为准
+func ResolveEntitlements(template *CardTemplate, card *Card) *Entitlements {
+	e := &Entitlements{
+		Features: []string{},
+		Quotas:   QuotaMap{},
+		Meters:   MeterLimits{},
+	}
+
+	merge := func(features StringArray, quotas QuotaMap, meters MeterLimits) {
+		for _, f := range features {
+			if !slices.Contains(e.Features, f) {
+				e.Features = append(e.Features, f)
+			}
+		}
+		for k, v := range quotas {
+			e.Quotas[k] = v
+		}
+		for k, v := range meters {
+			e.Meters[k] = v
+		}
+	}
+
+	if template != nil {
+		merge(template.Features, template.Quotas, template.Meters)
+	}
+	if card != nil {
+		merge(card.Features, card.Quotas, card.Meters)
+	}
+	return e
+}
+
+func (e *Entitlements) HasFeature(name string) bool {
+	return slices.Contains(e.Features, name)
+}
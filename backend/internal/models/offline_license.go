@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// OfflineLicense 离线授权签发记录
+// 离线校验不会访问服务端，吊销只对在线兑换生效
+type OfflineLicense struct {
+	ID          uint        `gorm:"primarykey" json:"id"`
+	LicenseID   string      `gorm:"uniqueIndex;not null" json:"license_id"`
+	ProjectID   uint        `gorm:"not null;index" json:"project_id"`
+	CardID      uint        `gorm:"not null;index" json:"card_id"`
+	HWID        string      `gorm:"not null" json:"hwid"`
+	Features    StringArray `gorm:"type:text" json:"features"`
+	CustomData  string      `gorm:"type:text" json:"custom_data"`
+	ExpireAt    *time.Time  `json:"expire_at"` // 为空表示永久
+	Content     string      `gorm:"type:text;not null" json:"content"`
+	Note        string      `json:"note"`
+	Revoked     bool        `gorm:"default:false" json:"revoked"`
+	ExchangedAt *time.Time  `json:"exchanged_at"` // 最近一次在线兑换时间
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,7 +25,64 @@ type Project struct {
 	UnbindCooldown   int            `gorm:"default:86400" json:"unbind_cooldown"`
 	EncryptionScheme string         `gorm:"default:aes-256-gcm" json:"encryption_scheme"`
 	EncryptionKey    string         `gorm:"not null" json:"encryption_key"`
+	KeyVersion       int            `gorm:"default:1" json:"key_version"`              // 每次更换密钥递增
+	PublicKey        string         `gorm:"-" json:"public_key,omitempty"`             // 密钥交换方案的服务端公钥，由私钥计算，不落库
+	SigningKey       string         `json:"-"`                                         // Ed25519 响应签名私钥种子，不返回给任何客户端
+	SigningPublicKey string         `gorm:"-" json:"signing_public_key"`               // 响应签名公钥，写入客户端配置
+	DataSchema       string         `gorm:"type:text" json:"custom_data_schema"`       // 客户端写入专属信息时校验的JSON Schema，为空不校验
+	DataMaxSize      int            `gorm:"default:65536" json:"custom_data_max_size"` // 客户端写入专属信息的大小上限(字节)
+	CardVarMaxCount  int            `gorm:"default:50" json:"card_var_max_count"`      // 每个卡密可写入的云变量个数上限
+	CardVarMaxSize   int            `gorm:"default:4096" json:"card_var_max_size"`     // 卡密云变量单个值的大小上限(字节)
+	Status           string         `gorm:"default:active" json:"status"`              // active/maintenance/suspended
+	StatusMessage    string         `json:"status_message"`                            // 维护或停用时返回给客户端的提示
+	StatusWhitelist  StringArray    `gorm:"type:text" json:"status_whitelist"`         // 维护期间仍可使用的卡密或设备码
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+const (
+	ProjectStatusActive      = "active"
+	ProjectStatusMaintenance = "maintenance"
+	ProjectStatusSuspended   = "suspended"
+)
+
+// ProjectStatusInfo 项目不可用时返回给客户端的状态
+type ProjectStatusInfo struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Allows 判断卡密或设备能否使用项目，维护中只放行白名单，停用时全部拒绝
+func (p *Project) Allows(cardKey, hwid string) bool {
+	switch p.Status {
+	case ProjectStatusMaintenance:
+		return (cardKey != "" && slices.Contains(p.StatusWhitelist, cardKey)) ||
+			(hwid != "" && slices.Contains(p.StatusWhitelist, hwid))
+	case ProjectStatusSuspended:
+		return false
+	}
+	return true
+}
+
+// StatusInfo 返回客户端看到的状态和提示，未填写提示时使用默认文案
+func (p *Project) StatusInfo() ProjectStatusInfo {
+	message := p.StatusMessage
+	if message == "" {
+		switch p.Status {
+		case ProjectStatusMaintenance:
+			message = "项目维护中，请稍后再试"
+		case ProjectStatusSuspended:
+			message = "项目已停用"
+		}
+	}
+	return ProjectStatusInfo{Status: p.Status, Message: message}
+}
+
+// StatusCode 项目不可用时的响应码，维护中 503，停用 403
+func (p *Project) StatusCode() int {
+	if p.Status == ProjectStatusSuspended {
+		return 403
+	}
+	return 503
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	KeyStatusStaged   = "staged"   // 已生成，仅接受明确指定该版本的请求，用于提前分发给新客户端
+	KeyStatusActive   = "active"   // 当前主密钥，未指定版本的请求默认使用
+	KeyStatusRetiring = "retiring" // 已被替换，宽限期内仍接受
+	KeyStatusRetired  = "retired"  // 已停用
+)
+
+type ProjectKey struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	ProjectID   uint       `gorm:"not null;uniqueIndex:idx_project_key_version" json:"project_id"`
+	Version     int        `gorm:"not null;uniqueIndex:idx_project_key_version" json:"version"`
+	Scheme      string     `gorm:"not null" json:"encryption_scheme"`
+	Key         string     `gorm:"not null" json:"encryption_key"`
+	Status      string     `gorm:"not null;default:staged" json:"status"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	RetireAt    *time.Time `json:"retire_at"` // 宽限期截止时间，仅 retiring 状态有效
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Accepts 密钥当前是否可用于解密请求
+func (k *ProjectKey) Accepts(now time.Time) bool {
+	switch k.Status {
+	case KeyStatusStaged, KeyStatusActive:
+		return true
+	case KeyStatusRetiring:
+		return k.RetireAt != nil && now.Before(*k.RetireAt)
+	default:
+		return false
+	}
+}
+
+// EffectiveStatus 宽限期已过的 retiring 密钥视为 retired
+func (k *ProjectKey) EffectiveStatus(now time.Time) string {
+	if k.Status == KeyStatusRetiring && !k.Accepts(now) {
+		return KeyStatusRetired
+	}
+	return k.Status
+}
@@ -0,0 +1,9 @@
+package models
+
+// RateLimitBucket 数据库限流后端的令牌桶状态
+type RateLimitBucket struct {
+	Key      string  `gorm:"primaryKey" json:"key"`
+	Tokens   float64 `gorm:"not null" json:"tokens"`
+	LastAt   int64   `gorm:"not null" json:"last_at"`         // 上次取令牌时间(UnixNano)
+	ExpireAt int64   `gorm:"not null;index" json:"expire_at"` // 过期后桶已回满，可清理(Unix秒)
+}
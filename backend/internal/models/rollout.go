@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RolloutRule 云变量的条件取值，填写的条件需全部满足，未填写的条件不限制
+type RolloutRule struct {
+	Name       string   `json:"name"` // 分组名，返回给客户端的 segment
+	Value      string   `json:"value"`
+	Percent    *int     `json:"percent,omitempty"`     // 0-100，按卡密ID稳定哈希落入前 percent% 的卡密
+	Templates  []uint   `json:"templates,omitempty"`   // 卡密模板
+	HWIDs      []string `json:"hwids,omitempty"`       // 当前会话的设备码
+	MinVersion string   `json:"min_version,omitempty"` // 客户端版本下限(含)
+	MaxVersion string   `json:"max_version,omitempty"` // 客户端版本上限(含)
+}
+
+type RolloutRules []RolloutRule
+
+func (r RolloutRules) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *RolloutRules) Scan(value interface{}) error {
+	*r = RolloutRules{}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return nil
+}
+
+// RolloutSubject 规则匹配的对象，CardID 为 0 表示未登录或免费模式
+type RolloutSubject struct {
+	CardID        uint
+	TemplateID    *uint
+	HWID          string
+	ClientVersion string
+}
+
+// SegmentDefault 未命中任何规则时的分组名
+const SegmentDefault = "default"
+
+// RolloutBucket 卡密在该变量下的分桶(0-99)，同一卡密和变量名结果固定，
+// 不同变量互不相关，调高比例时已命中的卡密保持命中
+func RolloutBucket(key string, cardID uint) int {
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + strconv.FormatUint(uint64(cardID), 10)))
+	return int(h.Sum32() % 100)
+}
+
+func (r *RolloutRule) Matches(key string, subject *RolloutSubject) bool {
+	if r.Percent != nil {
+		if subject.CardID == 0 || RolloutBucket(key, subject.CardID) >= *r.Percent {
+			return false
+		}
+	}
+	if len(r.Templates) > 0 {
+		if subject.TemplateID == nil || !slices.Contains(r.Templates, *subject.TemplateID) {
+			return false
+		}
+	}
+	if len(r.HWIDs) > 0 && !slices.Contains(r.HWIDs, subject.HWID) {
+		return false
+	}
+	if r.MinVersion != "" || r.MaxVersion != "" {
+		if subject.ClientVersion == "" {
+			return false
+		}
+		if r.MinVersion != "" && CompareVersions(subject.ClientVersion, r.MinVersion) < 0 {
+			return false
+		}
+		if r.MaxVersion != "" && CompareVersions(subject.ClientVersion, r.MaxVersion) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchRule 返回第一个命中的规则下标，都不命中时为 -1
+func (v *CloudVar) MatchRule(subject *RolloutSubject) int {
+	for i := range v.Rules {
+		if v.Rules[i].Matches(v.Key, subject) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyRollout 按命中的规则替换值并隐藏规则，用于返回给客户端
+func (v *CloudVar) ApplyRollout(subject *RolloutSubject) {
+	if len(v.Rules) > 0 {
+		v.Segment = SegmentDefault
+		if i := v.MatchRule(subject); i >= 0 {
+			v.Value = v.Rules[i].Value
+			v.Segment = v.Rules[i].Name
+		}
+	}
+	v.Rules = nil
+}
+
+// CompareVersions 按点分段比较版本号，忽略前缀 v，数字段按数值比较，其余按字符串比较
+func CompareVersions(a, b string) int {
+	x := strings.Split(strings.TrimPrefix(strings.TrimSpace(a), "v"), ".")
+	y := strings.Split(strings.TrimPrefix(strings.TrimSpace(b), "v"), ".")
+	for i := 0; i < max(len(x), len(y)); i++ {
+		var p, q string
+		if i < len(x) {
+			p = x[i]
+		}
+		if i < len(y) {
+			q = y[i]
+		}
+		m, errP := strconv.Atoi(p)
+		n, errQ := strconv.Atoi(q)
+		if p == "" {
+			m, errP = 0, nil
+		}
+		if q == "" {
+			n, errQ = 0, nil
+		}
+		if errP == nil && errQ == nil {
+			if m != n {
+				if m < n {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(p, q); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -7,15 +7,17 @@ import (
 )
 
 type Token struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	Token     string         `gorm:"uniqueIndex;not null" json:"token"`
-	CardID    *uint          `gorm:"index" json:"card_id"`
-	Card      *Card          `gorm:"foreignKey:CardID" json:"card,omitempty"`
-	ProjectID uint           `gorm:"not null;index" json:"project_id"`
-	ExpireAt  time.Time      `gorm:"not null" json:"expire_at"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID            uint           `gorm:"primarykey" json:"id"`
+	Token         string         `gorm:"uniqueIndex;not null" json:"token"`
+	CardID        *uint          `gorm:"index" json:"card_id"`
+	Card          *Card          `gorm:"foreignKey:CardID" json:"card,omitempty"`
+	ProjectID     uint           `gorm:"not null;index" json:"project_id"`
+	HWID          string         `json:"hwid"`           // 登录时的设备码，用于签发租约
+	ClientVersion string         `json:"client_version"` // 登录时上报的客户端版本，用于云变量灰度
+	ExpireAt      time.Time      `gorm:"not null" json:"expire_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (t *Token) IsExpired() bool {
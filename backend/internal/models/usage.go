@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+)
+
+const (
+	MeterPeriodDaily   = "daily"
+	MeterPeriodMonthly = "monthly"
+	MeterPeriodTotal   = "total"
+)
+
+// MeterLimit 计量项配额，Limit 为 -1 表示只计量不限制
+type MeterLimit struct {
+	Limit  int64  `json:"limit"`
+	Period string `json:"period"`
+}
+
+func (m MeterLimit) Valid() bool {
+	if m.Limit < -1 {
+		return false
+	}
+	switch m.Period {
+	case MeterPeriodDaily, MeterPeriodMonthly, MeterPeriodTotal:
+		return true
+	}
+	return false
+}
+
+// PeriodKey 当前计量周期的标识，周期变化时计数清零
+func (m MeterLimit) PeriodKey(now time.Time) string {
+	switch m.Period {
+	case MeterPeriodDaily:
+		return now.Format("2006-01-02")
+	case MeterPeriodMonthly:
+		return now.Format("2006-01")
+	}
+	return MeterPeriodTotal
+}
+
+// ResetAt 下一次清零时间，total 周期返回 nil
+func (m MeterLimit) ResetAt(now time.Time) *time.Time {
+	var t time.Time
+	switch m.Period {
+	case MeterPeriodDaily:
+		t = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	case MeterPeriodMonthly:
+		t = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	default:
+		return nil
+	}
+	return &t
+}
+
+// MeterLimits 计量项名称到配额的映射
+type MeterLimits map[string]MeterLimit
+
+func (m MeterLimits) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *MeterLimits) Scan(value interface{}) error {
+	*m = MeterLimits{}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return nil
+}
+
+// UsageCounter 每个卡密每个计量项一行，周期变化时原地清零，不保留历史
+type UsageCounter struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CardID    uint      `gorm:"not null;uniqueIndex:idx_usage_card_meter" json:"card_id"`
+	Meter     string    `gorm:"not null;uniqueIndex:idx_usage_card_meter" json:"meter"`
+	PeriodKey string    `gorm:"not null" json:"period_key"`
+	Used      int64     `gorm:"not null;default:0" json:"used"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AnnouncementService struct{}
+
+func NewAnnouncementService() *AnnouncementService {
+	return &AnnouncementService{}
+}
+
+var (
+	ErrAnnouncementNotFound = errors.New("公告不存在")
+	ErrAnnouncementNoReader = errors.New("未绑定卡密或设备码，无法确认已读")
+)
+
+type CreateAnnouncementRequest struct {
+	ProjectID  uint       `json:"project_id"`
+	CardID     *uint      `json:"card_id"` // 填写时只发给该卡密
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Priority   int        `json:"priority"`
+	StartAt    *time.Time `json:"start_at"`
+	EndAt      *time.Time `json:"end_at"`
+	Templates  []uint     `json:"templates"`
+	MinVersion string     `json:"min_version"`
+	MaxVersion string     `json:"max_version"`
+}
+
+// UpdateAnnouncementRequest 未填写的字段保持不变，ClearSchedule 先清空生效时间再按提交值设置
+type UpdateAnnouncementRequest struct {
+	Title         *string    `json:"title"`
+	Content       *string    `json:"content"`
+	Priority      *int       `json:"priority"`
+	ClearSchedule bool       `json:"clear_schedule"`
+	StartAt       *time.Time `json:"start_at"`
+	EndAt         *time.Time `json:"end_at"`
+	Templates     *[]uint    `json:"templates"`
+	MinVersion    *string    `json:"min_version"`
+	MaxVersion    *string    `json:"max_version"`
+}
+
+type ListAnnouncementFilter struct {
+	ProjectID uint
+	CardID    uint
+	Scope     string // project 只看项目公告，card 只看卡密消息
+	Keyword   string
+}
+
+func (s *AnnouncementService) Create(req *CreateAnnouncementRequest, adminID uint) (*models.Announcement, error) {
+	var project models.Project
+	if err := database.DB.First(&project, req.ProjectID).Error; err != nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	announcement := &models.Announcement{
+		ProjectID:  req.ProjectID,
+		CardID:     req.CardID,
+		Title:      strings.TrimSpace(req.Title),
+		Content:    req.Content,
+		Priority:   req.Priority,
+		StartAt:    req.StartAt,
+		EndAt:      req.EndAt,
+		Templates:  req.Templates,
+		MinVersion: strings.TrimSpace(req.MinVersion),
+		MaxVersion: strings.TrimSpace(req.MaxVersion),
+		CreatedBy:  adminID,
+	}
+	if announcement.CardID != nil && *announcement.CardID == 0 {
+		announcement.CardID = nil
+	}
+	if err := validateAnnouncement(announcement); err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.Create(announcement).Error; err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// validateAnnouncement 校验标题、生效时间，以及卡密和模板属于同一项目
+func validateAnnouncement(a *models.Announcement) error {
+	if a.Title == "" {
+		return errors.New("标题不能为空")
+	}
+	if a.StartAt != nil && a.EndAt != nil && !a.EndAt.After(*a.StartAt) {
+		return errors.New("结束时间需晚于开始时间")
+	}
+	if a.MinVersion != "" && a.MaxVersion != "" && models.CompareVersions(a.MinVersion, a.MaxVersion) > 0 {
+		return errors.New("版本下限不能大于上限")
+	}
+	if a.CardID != nil {
+		var count int64
+		if err := database.DB.Model(&models.Card{}).Where("id = ? AND project_id = ?", *a.CardID, a.ProjectID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("卡密不存在或不属于该项目")
+		}
+	}
+	if len(a.Templates) > 0 {
+		var count int64
+		if err := database.DB.Model(&models.CardTemplate{}).Where("id IN ? AND project_id = ?", []uint(a.Templates), a.ProjectID).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(a.Templates) {
+			return errors.New("模板不存在或不属于该项目")
+		}
+	}
+	return nil
+}
+
+func (s *AnnouncementService) Get(id uint) (*models.Announcement, error) {
+	var announcement models.Announcement
+	if err := database.DB.First(&announcement, id).Error; err != nil {
+		return nil, ErrAnnouncementNotFound
+	}
+	return &announcement, nil
+}
+
+func (s *AnnouncementService) List(filter *ListAnnouncementFilter, page, pageSize int) ([]models.Announcement, int64, error) {
+	var announcements []models.Announcement
+	var total int64
+
+	query := database.DB.Model(&models.Announcement{})
+	if filter.ProjectID > 0 {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.CardID > 0 {
+		query = query.Where("card_id = ?", filter.CardID)
+	}
+	switch filter.Scope {
+	case "project":
+		query = query.Where("card_id IS NULL")
+	case "card":
+		query = query.Where("card_id IS NOT NULL")
+	}
+	if filter.Keyword != "" {
+		keyword := "%" + escapeLikeString(filter.Keyword) + "%"
+		query = query.Where("title LIKE ? ESCAPE '\\' OR content LIKE ? ESCAPE '\\'", keyword, keyword)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+
+	if err := query.Order("priority DESC, id DESC").Find(&announcements).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if len(announcements) == 0 {
+		return announcements, total, nil
+	}
+	ids := make([]uint, len(announcements))
+	cardIDs := []uint{}
+	for i := range announcements {
+		ids[i] = announcements[i].ID
+		if announcements[i].CardID != nil {
+			cardIDs = append(cardIDs, *announcements[i].CardID)
+		}
+	}
+
+	var readCounts []struct {
+		AnnouncementID uint
+		Count          int64
+	}
+	if err := database.DB.Model(&models.AnnouncementRead{}).Select("announcement_id, COUNT(*) AS count").
+		Where("announcement_id IN ?", ids).Group("announcement_id").Scan(&readCounts).Error; err != nil {
+		return nil, 0, err
+	}
+	counts := make(map[uint]int64, len(readCounts))
+	for _, c := range readCounts {
+		counts[c.AnnouncementID] = c.Count
+	}
+	for i := range announcements {
+		announcements[i].ReadCount = counts[announcements[i].ID]
+	}
+
+	if len(cardIDs) > 0 {
+		var cards []models.Card
+		if err := database.DB.Select("id", "card_key").Where("id IN ?", cardIDs).Find(&cards).Error; err != nil {
+			return nil, 0, err
+		}
+		keys := make(map[uint]string, len(cards))
+		for _, card := range cards {
+			keys[card.ID] = card.CardKey
+		}
+		for i := range announcements {
+			if announcements[i].CardID != nil {
+				announcements[i].CardKey = keys[*announcements[i].CardID]
+			}
+		}
+	}
+	return announcements, total, nil
+}
+
+func (s *AnnouncementService) Update(id uint, req *UpdateAnnouncementRequest) (*models.Announcement, error) {
+	announcement, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Title != nil {
+		announcement.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Content != nil {
+		announcement.Content = *req.Content
+	}
+	if req.Priority != nil {
+		announcement.Priority = *req.Priority
+	}
+	if req.ClearSchedule {
+		announcement.StartAt = nil
+		announcement.EndAt = nil
+	}
+	if req.StartAt != nil {
+		announcement.StartAt = req.StartAt
+	}
+	if req.EndAt != nil {
+		announcement.EndAt = req.EndAt
+	}
+	if req.Templates != nil {
+		announcement.Templates = *req.Templates
+	}
+	if req.MinVersion != nil {
+		announcement.MinVersion = strings.TrimSpace(*req.MinVersion)
+	}
+	if req.MaxVersion != nil {
+		announcement.MaxVersion = strings.TrimSpace(*req.MaxVersion)
+	}
+	if err := validateAnnouncement(announcement); err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.Save(announcement).Error; err != nil {
+		return nil, err
+	}
+	return announcement, nil
+}
+
+// Delete 直接删除公告及已读记录，不进入回收站
+func (s *AnnouncementService) Delete(id uint) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := purgeAnnouncements(tx, []uint{id}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func purgeAnnouncements(tx *gorm.DB, ids []uint) error {
+	if err := tx.Where("announcement_id IN ?", ids).Delete(&models.AnnouncementRead{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Announcement{}).Error
+}
+
+// readerKey 已读记录的归属，付费模式按卡密，免费模式按设备码，都没有时无法记录
+func (r *varReader) readerKey() (uint, string, bool) {
+	if r.card != nil {
+		return r.card.ID, "", true
+	}
+	if r.hwid != "" {
+		return 0, r.hwid, true
+	}
+	return 0, "", false
+}
+
+// clientAnnouncements 返回当前生效且推送给该会话的公告和消息，按优先级排序，unreadOnly 时过滤已读
+func clientAnnouncements(projectID uint, reader *varReader, unreadOnly bool) ([]models.Announcement, error) {
+	query := database.DB.Where("project_id = ?", projectID)
+	if reader.card != nil {
+		query = query.Where("card_id IS NULL OR card_id = ?", reader.card.ID)
+	} else {
+		query = query.Where("card_id IS NULL")
+	}
+	var candidates []models.Announcement
+	if err := query.Order("priority DESC, id DESC").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	subject := reader.subject()
+	list := make([]models.Announcement, 0, len(candidates))
+	ids := make([]uint, 0, len(candidates))
+	for _, a := range candidates {
+		if a.Active(now) && a.Matches(subject) {
+			list = append(list, a)
+			ids = append(ids, a.ID)
+		}
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	if cardID, hwid, ok := reader.readerKey(); ok {
+		var readIDs []uint
+		if err := database.DB.Model(&models.AnnouncementRead{}).
+			Where("announcement_id IN ? AND card_id = ? AND hw_id = ?", ids, cardID, hwid).
+			Pluck("announcement_id", &readIDs).Error; err != nil {
+			return nil, err
+		}
+		read := make(map[uint]bool, len(readIDs))
+		for _, id := range readIDs {
+			read[id] = true
+		}
+		for i := range list {
+			list[i].Read = read[list[i].ID]
+		}
+	}
+
+	if unreadOnly {
+		unread := list[:0]
+		for _, a := range list {
+			if !a.Read {
+				unread = append(unread, a)
+			}
+		}
+		list = unread
+	}
+	for i := range list {
+		list[i].Templates = nil
+	}
+	return list, nil
+}
+
+// ClientList 客户端拉取公告和消息
+func (s *AnnouncementService) ClientList(token *models.Token, unreadOnly bool) ([]models.Announcement, error) {
+	reader, err := newVarReader(token)
+	if err != nil {
+		return nil, err
+	}
+	return clientAnnouncements(token.ProjectID, reader, unreadOnly)
+}
+
+// Ack 确认已读，只记录当前推送给该会话的公告，返回新确认的数量
+func (s *AnnouncementService) Ack(token *models.Token, ids []uint) (int, error) {
+	if len(ids) == 0 {
+		return 0, errors.New("未选择公告")
+	}
+	reader, err := newVarReader(token)
+	if err != nil {
+		return 0, err
+	}
+	cardID, hwid, ok := reader.readerKey()
+	if !ok {
+		return 0, ErrAnnouncementNoReader
+	}
+
+	visible, err := clientAnnouncements(token.ProjectID, reader, true)
+	if err != nil {
+		return 0, err
+	}
+	acked := 0
+	now := time.Now()
+	for _, a := range visible {
+		for _, id := range ids {
+			if a.ID != id {
+				continue
+			}
+			read := models.AnnouncementRead{AnnouncementID: a.ID, CardID: cardID, HWID: hwid, ReadAt: now}
+			result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&read)
+			if result.Error != nil {
+				return acked, result.Error
+			}
+			acked += int(result.RowsAffected)
+			break
+		}
+	}
+	return acked, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+func TestAnnouncementListReadCounts(t *testing.T) {
+	project, reader := setupVarReader(t)
+	svc := NewAnnouncementService()
+
+	notice, err := svc.Create(&CreateAnnouncementRequest{ProjectID: project.ID, Title: "notice"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cardID := reader.card.ID
+	message, err := svc.Create(&CreateAnnouncementRequest{ProjectID: project.ID, CardID: &cardID, Title: "message"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, read := range []models.AnnouncementRead{
+		{AnnouncementID: notice.ID, CardID: cardID, ReadAt: time.Now()},
+		{AnnouncementID: notice.ID, HWID: "hw", ReadAt: time.Now()},
+	} {
+		if err := database.DB.Create(&read).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, total, err := svc.List(&ListAnnouncementFilter{ProjectID: project.ID}, 1, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(list) != 2 {
+		t.Fatalf("list = %d (total %d), want 2", len(list), total)
+	}
+	for _, a := range list {
+		switch a.ID {
+		case notice.ID:
+			if a.ReadCount != 2 {
+				t.Errorf("notice read count = %d, want 2", a.ReadCount)
+			}
+		case message.ID:
+			if a.ReadCount != 0 || a.CardKey != reader.card.CardKey {
+				t.Errorf("message = %+v, want 0 reads and card key %s", a, reader.card.CardKey)
+			}
+		}
+	}
+}
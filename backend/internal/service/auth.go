@@ -27,16 +27,22 @@ var (
 )
 
 type LoginRequest struct {
-	CardKey     string `json:"card_key"`
-	HWID        string `json:"hwid,omitempty"`
-	IP          string `json:"ip,omitempty"`
-	ProjectUUID string `json:"project_uuid"`
+	CardKey       string `json:"card_key"`
+	HWID          string `json:"hwid,omitempty"`
+	IP            string `json:"ip,omitempty"`
+	ProjectUUID   string `json:"project_uuid"`
+	ClientVersion string `json:"client_version,omitempty"` // 用于按客户端版本灰度云变量
 }
 
 type LoginResponse struct {
 	Token    string       `json:"token"`
 	ExpireAt time.Time    `json:"expire_at"`
 	Card     *models.Card `json:"card"`
+	Lease    string       `json:"lease"` // 签名租约，客户端可离线校验
+	// 卡密权益(功能开关和配额)，免费模式为空
+	Entitlements *models.Entitlements `json:"entitlements"`
+	// 未读的公告和卡密消息，确认后不再返回
+	Announcements []models.Announcement `json:"announcements"`
 }
 
 func (s *AuthService) CardLogin(req *LoginRequest) (*LoginResponse, error) {
@@ -45,26 +51,33 @@ func (s *AuthService) CardLogin(req *LoginRequest) (*LoginResponse, error) {
 		return nil, errors.New("认证失败")
 	}
 
+	// 维护或停用时在激活卡密之前拒绝
+	if err := checkProjectStatus(&project, req.CardKey, req.HWID); err != nil {
+		return nil, err
+	}
+
 	// 免费模式: 跳过所有验证,直接返回Token
 	if project.Mode == "free" {
-		tokenStr := uuid.New().String()
-		expireAt := time.Now().Add(time.Duration(project.TokenExpire) * time.Second)
-
-		token := &models.Token{
-			Token:     tokenStr,
-			CardID:    nil,
-			ProjectID: project.ID,
-			ExpireAt:  expireAt,
+		token, err := createToken(&project, nil, req.HWID, req.ClientVersion)
+		if err != nil {
+			return nil, err
 		}
-
-		if err := database.DB.Create(token).Error; err != nil {
+		lease, err := issueLease(&project, token, nil)
+		if err != nil {
+			return nil, err
+		}
+		announcements, err := clientAnnouncements(project.ID, &varReader{hwid: req.HWID, clientVersion: req.ClientVersion}, true)
+		if err != nil {
 			return nil, err
 		}
 
 		return &LoginResponse{
-			Token:    tokenStr,
-			ExpireAt: expireAt,
-			Card:     nil,
+			Token:         token.Token,
+			ExpireAt:      token.ExpireAt,
+			Card:          nil,
+			Lease:         lease,
+			Entitlements:  resolveCardEntitlements(nil),
+			Announcements: announcements,
 		}, nil
 	}
 
@@ -135,28 +148,47 @@ func (s *AuthService) CardLogin(req *LoginRequest) (*LoginResponse, error) {
 		}
 	}
 
-	tokenStr := uuid.New().String()
-	expireAt := time.Now().Add(time.Duration(project.TokenExpire) * time.Second)
-
 	cardID := card.ID
-	token := &models.Token{
-		Token:     tokenStr,
-		CardID:    &cardID,
-		ProjectID: project.ID,
-		ExpireAt:  expireAt,
+	token, err := createToken(&project, &cardID, req.HWID, req.ClientVersion)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := database.DB.Create(token).Error; err != nil {
+	lease, err := issueLease(&project, token, &card)
+	if err != nil {
+		return nil, err
+	}
+	announcements, err := clientAnnouncements(project.ID, &varReader{card: &card, hwid: req.HWID, clientVersion: req.ClientVersion}, true)
+	if err != nil {
 		return nil, err
 	}
 
 	return &LoginResponse{
-		Token:    tokenStr,
-		ExpireAt: expireAt,
-		Card:     &card,
+		Token:         token.Token,
+		ExpireAt:      token.ExpireAt,
+		Card:          &card,
+		Lease:         lease,
+		Entitlements:  resolveCardEntitlements(&card),
+		Announcements: announcements,
 	}, nil
 }
 
+// createToken 签发客户端Token，免费模式下 cardID 为空
+func createToken(project *models.Project, cardID *uint, hwid, clientVersion string) (*models.Token, error) {
+	token := &models.Token{
+		Token:         uuid.New().String(),
+		CardID:        cardID,
+		ProjectID:     project.ID,
+		HWID:          hwid,
+		ClientVersion: clientVersion,
+		ExpireAt:      time.Now().Add(time.Duration(project.TokenExpire) * time.Second),
+	}
+
+	if err := database.DB.Create(token).Error; err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
 type AdminLoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
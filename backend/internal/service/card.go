@@ -2,13 +2,16 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/nextkey/nextkey/backend/internal/database"
 	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/jsonschema"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
+	"gorm.io/gorm"
 )
 
 // escapeLikeString 转义LIKE查询中的特殊字符
@@ -38,6 +41,11 @@ type CreateCardRequest struct {
 	MaxHWID     int    `json:"max_hwid"`
 	MaxIP       int    `json:"max_ip"`
 	Note        string `json:"note"`
+	// 指定模板时，未填写的时长、类型和设备/IP上限取模板的值
+	TemplateID *uint              `json:"template_id"`
+	Features   models.StringArray `json:"features"`
+	Quotas     models.QuotaMap    `json:"quotas"`
+	Meters     models.MeterLimits `json:"meters"`
 }
 
 type UpdateCardRequest struct {
@@ -48,8 +56,13 @@ type UpdateCardRequest struct {
 	MaxHWID    *int                `json:"max_hwid"`
 	MaxIP      *int                `json:"max_ip"`
 	CustomData *string             `json:"custom_data"`
+	AdminData  *string             `json:"admin_data"` // 管理员专属信息，客户端只读
 	HWIDList   *models.StringArray `json:"hwid_list"`
 	IPList     *models.StringArray `json:"ip_list"`
+	TemplateID *uint               `json:"template_id"` // 0 表示取消模板
+	Features   *models.StringArray `json:"features"`
+	Quotas     *models.QuotaMap    `json:"quotas"`
+	Meters     *models.MeterLimits `json:"meters"`
 }
 
 type CardListFilter struct {
@@ -91,6 +104,29 @@ func (s *CardService) CreateBatch(req *CreateCardRequest) ([]models.Card, error)
 		return nil, errors.New("字符类型无效")
 	}
 
+	if err := validateMeters(req.Meters); err != nil {
+		return nil, err
+	}
+
+	if req.TemplateID != nil {
+		template, err := findProjectTemplate(*req.TemplateID, req.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		if req.Duration == 0 {
+			req.Duration = template.Duration
+		}
+		if req.CardType == "" {
+			req.CardType = template.CardType
+		}
+		if req.MaxHWID == 0 {
+			req.MaxHWID = template.MaxHWID
+		}
+		if req.MaxIP == 0 {
+			req.MaxIP = template.MaxIP
+		}
+	}
+
 	cards := make([]models.Card, 0, req.Count)
 
 	for i := 0; i < req.Count; i++ {
@@ -102,15 +138,19 @@ func (s *CardService) CreateBatch(req *CreateCardRequest) ([]models.Card, error)
 		}
 
 		card := models.Card{
-			CardKey:   cardKey,
-			ProjectID: req.ProjectID,
-			Duration:  req.Duration,
-			CardType:  req.CardType,
-			MaxHWID:   req.MaxHWID,
-			MaxIP:     req.MaxIP,
-			Note:      req.Note,
-			HWIDList:  make(models.StringArray, 0),
-			IPList:    make(models.StringArray, 0),
+			CardKey:    cardKey,
+			ProjectID:  req.ProjectID,
+			Duration:   req.Duration,
+			CardType:   req.CardType,
+			MaxHWID:    req.MaxHWID,
+			MaxIP:      req.MaxIP,
+			Note:       req.Note,
+			HWIDList:   make(models.StringArray, 0),
+			IPList:     make(models.StringArray, 0),
+			TemplateID: req.TemplateID,
+			Features:   req.Features,
+			Quotas:     req.Quotas,
+			Meters:     req.Meters,
 		}
 
 		if err := database.DB.Create(&card).Error; err != nil {
@@ -298,6 +338,10 @@ func (s *CardService) Update(id uint, req *UpdateCardRequest) (*models.Card, err
 	}
 	if req.CustomData != nil {
 		card.CustomData = *req.CustomData
+		card.DataVersion++
+	}
+	if req.AdminData != nil {
+		card.AdminData = *req.AdminData
 	}
 	if req.HWIDList != nil {
 		card.HWIDList = *req.HWIDList
@@ -305,6 +349,28 @@ func (s *CardService) Update(id uint, req *UpdateCardRequest) (*models.Card, err
 	if req.IPList != nil {
 		card.IPList = *req.IPList
 	}
+	if req.TemplateID != nil {
+		if *req.TemplateID == 0 {
+			card.TemplateID = nil
+		} else {
+			if _, err := findProjectTemplate(*req.TemplateID, card.ProjectID); err != nil {
+				return nil, err
+			}
+			card.TemplateID = req.TemplateID
+		}
+	}
+	if req.Features != nil {
+		card.Features = *req.Features
+	}
+	if req.Quotas != nil {
+		card.Quotas = *req.Quotas
+	}
+	if req.Meters != nil {
+		if err := validateMeters(*req.Meters); err != nil {
+			return nil, err
+		}
+		card.Meters = *req.Meters
+	}
 
 	if err := database.DB.Save(&card).Error; err != nil {
 		return nil, err
@@ -314,33 +380,140 @@ func (s *CardService) Update(id uint, req *UpdateCardRequest) (*models.Card, err
 }
 
 func (s *CardService) Delete(id uint) error {
-	return database.DB.Delete(&models.Card{}, id).Error
+	return s.BatchDelete([]uint{id})
+}
+
+// ErrCustomDataConflict 客户端提交的版本号与当前版本不一致
+var ErrCustomDataConflict = errors.New("专属信息已被修改，请重新获取后再提交")
+
+type UpdateCustomDataRequest struct {
+	CustomData string `json:"custom_data"`
+	Version    *int   `json:"version"` // 读取时的版本号，为空时不做并发检查(兼容旧客户端)
+}
+
+type CustomDataResponse struct {
+	CustomData string `json:"custom_data"`
+	AdminData  string `json:"admin_data"`
+	Version    int    `json:"version"`
 }
 
-func (s *CardService) UpdateCustomData(cardID uint, customData string) error {
+func (s *CardService) GetCustomData(cardID uint) (*CustomDataResponse, error) {
 	var card models.Card
 	if err := database.DB.First(&card, cardID).Error; err != nil {
-		return errors.New("卡密不存在")
+		return nil, errors.New("卡密不存在")
 	}
-
-	card.CustomData = customData
-	return database.DB.Save(&card).Error
+	return &CustomDataResponse{
+		CustomData: card.CustomData,
+		AdminData:  card.AdminData,
+		Version:    card.DataVersion,
+	}, nil
 }
 
-func (s *CardService) Heartbeat(cardID uint, projectID uint) error {
-	var token models.Token
-	if err := database.DB.Where("card_id = ? AND project_id = ?", cardID, projectID).
-		Order("created_at DESC").First(&token).Error; err != nil {
-		return errors.New("Token不存在")
+// UpdateCustomData 客户端写入专属信息，按项目规则校验大小和结构，只能写客户端区域
+func (s *CardService) UpdateCustomData(cardID uint, req *UpdateCustomDataRequest) (*CustomDataResponse, error) {
+	var card models.Card
+	if err := database.DB.Preload("Project").First(&card, cardID).Error; err != nil {
+		return nil, errors.New("卡密不存在")
+	}
+	if card.Project == nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	maxSize := card.Project.DataMaxSize
+	if maxSize <= 0 {
+		maxSize = 65536
+	}
+	if len(req.CustomData) > maxSize {
+		return nil, fmt.Errorf("专属信息不能超过 %d 字节", maxSize)
 	}
 
+	if card.Project.DataSchema != "" {
+		schema, err := jsonschema.Compile(card.Project.DataSchema)
+		if err != nil {
+			return nil, err
+		}
+		if err := schema.Validate([]byte(req.CustomData)); err != nil {
+			return nil, fmt.Errorf("专属信息不符合项目规则: %v", err)
+		}
+	}
+
+	query := database.DB.Model(&models.Card{}).Where("id = ?", cardID)
+	if req.Version != nil {
+		query = query.Where("data_version = ?", *req.Version)
+	}
+	result := query.Updates(map[string]interface{}{
+		"custom_data":  req.CustomData,
+		"data_version": gorm.Expr("data_version + 1"),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrCustomDataConflict
+	}
+
+	return s.GetCustomData(cardID)
+}
+
+// ErrCardUnavailable 卡密已冻结或过期，心跳不再续期
+var ErrCardUnavailable = errors.New("卡密已冻结或过期")
+
+type HeartbeatRequest struct {
+	VarRevision *int64 `json:"var_revision"` // 填写时在响应中附带该修订号之后的云变量变更
+}
+
+type HeartbeatResponse struct {
+	Message       string                `json:"message"`
+	ExpireAt      time.Time             `json:"expire_at"`
+	Lease         string                `json:"lease"`
+	CloudVars     *CloudVarChanges      `json:"cloud_vars,omitempty"`
+	Announcements []models.Announcement `json:"announcements"` // 未读的公告和卡密消息
+}
+
+// Heartbeat 续期当前Token并签发新租约，免费模式只签发租约
+func (s *CardService) Heartbeat(token *models.Token, req *HeartbeatRequest) (*HeartbeatResponse, error) {
 	var project models.Project
-	if err := database.DB.First(&project, projectID).Error; err != nil {
-		return errors.New("项目不存在")
+	if err := database.DB.First(&project, token.ProjectID).Error; err != nil {
+		return nil, errors.New("项目不存在")
 	}
 
-	token.ExpireAt = time.Now().Add(time.Duration(project.TokenExpire) * time.Second)
-	return database.DB.Save(&token).Error
+	var card *models.Card
+	if token.CardID != nil {
+		card = &models.Card{}
+		if err := database.DB.First(card, *token.CardID).Error; err != nil {
+			return nil, errors.New("卡密不存在")
+		}
+		if card.IsFrozen() || card.IsExpired() {
+			return nil, ErrCardUnavailable
+		}
+
+		token.ExpireAt = time.Now().Add(time.Duration(project.TokenExpire) * time.Second)
+		if err := database.DB.Model(token).Update("expire_at", token.ExpireAt).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	lease, err := issueLease(&project, token, card)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &HeartbeatResponse{
+		Message:  "心跳成功",
+		ExpireAt: token.ExpireAt,
+		Lease:    lease,
+	}
+	reader := &varReader{card: card, hwid: token.HWID, clientVersion: token.ClientVersion}
+	if resp.Announcements, err = clientAnnouncements(project.ID, reader, true); err != nil {
+		return nil, err
+	}
+	if req.VarRevision != nil {
+		if resp.CloudVars, err = varChanges(project.ID, reader, *req.VarRevision); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
 }
 
 func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
@@ -348,6 +521,17 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 		return errors.New("未选择卡密")
 	}
 
+	if req.TemplateID != nil && *req.TemplateID != 0 {
+		if _, err := s.templateForCards(*req.TemplateID, ids); err != nil {
+			return err
+		}
+	}
+	if req.Meters != nil {
+		if err := validateMeters(*req.Meters); err != nil {
+			return err
+		}
+	}
+
 	tx := database.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -389,6 +573,10 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 			}
 			if req.CustomData != nil {
 				cards[i].CustomData = *req.CustomData
+				cards[i].DataVersion++
+			}
+			if req.AdminData != nil {
+				cards[i].AdminData = *req.AdminData
 			}
 			if req.HWIDList != nil {
 				cards[i].HWIDList = *req.HWIDList
@@ -396,6 +584,21 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 			if req.IPList != nil {
 				cards[i].IPList = *req.IPList
 			}
+			if req.TemplateID != nil {
+				cards[i].TemplateID = req.TemplateID
+				if *req.TemplateID == 0 {
+					cards[i].TemplateID = nil
+				}
+			}
+			if req.Features != nil {
+				cards[i].Features = *req.Features
+			}
+			if req.Quotas != nil {
+				cards[i].Quotas = *req.Quotas
+			}
+			if req.Meters != nil {
+				cards[i].Meters = *req.Meters
+			}
 
 			if err := tx.Save(&cards[i]).Error; err != nil {
 				tx.Rollback()
@@ -419,6 +622,10 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 		}
 		if req.CustomData != nil {
 			updates["custom_data"] = *req.CustomData
+			updates["data_version"] = gorm.Expr("data_version + 1")
+		}
+		if req.AdminData != nil {
+			updates["admin_data"] = *req.AdminData
 		}
 		if req.HWIDList != nil {
 			updates["hwid_list"] = *req.HWIDList
@@ -426,6 +633,22 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 		if req.IPList != nil {
 			updates["ip_list"] = *req.IPList
 		}
+		if req.TemplateID != nil {
+			if *req.TemplateID == 0 {
+				updates["template_id"] = nil
+			} else {
+				updates["template_id"] = *req.TemplateID
+			}
+		}
+		if req.Features != nil {
+			updates["features"] = *req.Features
+		}
+		if req.Quotas != nil {
+			updates["quotas"] = *req.Quotas
+		}
+		if req.Meters != nil {
+			updates["meters"] = *req.Meters
+		}
 
 		if len(updates) == 0 {
 			tx.Rollback()
@@ -441,11 +664,43 @@ func (s *CardService) BatchUpdate(ids []uint, req *UpdateCardRequest) error {
 	return tx.Commit().Error
 }
 
+// templateForCards 批量指定模板时，所选卡密必须都属于模板所在项目
+func (s *CardService) templateForCards(templateID uint, ids []uint) (*models.CardTemplate, error) {
+	var template models.CardTemplate
+	if err := database.DB.First(&template, templateID).Error; err != nil {
+		return nil, errors.New("模板不存在")
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.Card{}).Where("id IN ? AND project_id <> ?", ids, template.ProjectID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("所选卡密不属于模板所在项目")
+	}
+	return &template, nil
+}
+
+// GetEntitlements 客户端查询当前卡密的权益
+func (s *CardService) GetEntitlements(cardID uint) (*models.Entitlements, error) {
+	var card models.Card
+	if err := database.DB.First(&card, cardID).Error; err != nil {
+		return nil, errors.New("卡密不存在")
+	}
+	return resolveCardEntitlements(&card), nil
+}
+
 func (s *CardService) BatchDelete(ids []uint) error {
 	if len(ids) == 0 {
 		return errors.New("未选择卡密")
 	}
 
+	// 卡密级变量和卡密消息随卡密一起进入回收站，恢复卡密时一并恢复
+	var cloudVars []models.CloudVar
+	if err := database.DB.Where("card_id IN ?", ids).Find(&cloudVars).Error; err != nil {
+		return err
+	}
+
 	tx := database.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -457,6 +712,14 @@ func (s *CardService) BatchDelete(ids []uint) error {
 		tx.Rollback()
 		return err
 	}
+	if err := deleteCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("card_id IN ?", ids).Delete(&models.Announcement{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	return tx.Commit().Error
 }
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+type CardTemplateService struct{}
+
+func NewCardTemplateService() *CardTemplateService {
+	return &CardTemplateService{}
+}
+
+type CreateCardTemplateRequest struct {
+	ProjectID uint               `json:"project_id"`
+	Name      string             `json:"name"`
+	Duration  int                `json:"duration"`
+	CardType  string             `json:"card_type"`
+	MaxHWID   int                `json:"max_hwid"`
+	MaxIP     int                `json:"max_ip"`
+	Features  models.StringArray `json:"features"`
+	Quotas    models.QuotaMap    `json:"quotas"`
+	Meters    models.MeterLimits `json:"meters"`
+	Note      string             `json:"note"`
+}
+
+type UpdateCardTemplateRequest struct {
+	Name     *string             `json:"name"`
+	Duration *int                `json:"duration"`
+	CardType *string             `json:"card_type"`
+	MaxHWID  *int                `json:"max_hwid"`
+	MaxIP    *int                `json:"max_ip"`
+	Features *models.StringArray `json:"features"`
+	Quotas   *models.QuotaMap    `json:"quotas"`
+	Meters   *models.MeterLimits `json:"meters"`
+	Note     *string             `json:"note"`
+}
+
+func (s *CardTemplateService) Create(req *CreateCardTemplateRequest) (*models.CardTemplate, error) {
+	if req.Name == "" {
+		return nil, errors.New("模板名称不能为空")
+	}
+	if err := validateMeters(req.Meters); err != nil {
+		return nil, err
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, req.ProjectID).Error; err != nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	template := &models.CardTemplate{
+		ProjectID: req.ProjectID,
+		Name:      req.Name,
+		Duration:  req.Duration,
+		CardType:  req.CardType,
+		MaxHWID:   req.MaxHWID,
+		MaxIP:     req.MaxIP,
+		Features:  req.Features,
+		Quotas:    req.Quotas,
+		Meters:    req.Meters,
+		Note:      req.Note,
+	}
+	if err := database.DB.Create(template).Error; err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (s *CardTemplateService) Get(id uint) (*models.CardTemplate, error) {
+	var template models.CardTemplate
+	if err := database.DB.First(&template, id).Error; err != nil {
+		return nil, errors.New("模板不存在")
+	}
+	return &template, nil
+}
+
+func (s *CardTemplateService) List(projectID uint, page, pageSize int) ([]models.CardTemplate, int64, error) {
+	var templates []models.CardTemplate
+	var total int64
+
+	query := database.DB.Model(&models.CardTemplate{})
+	if projectID > 0 {
+		query = query.Where("project_id = ?", projectID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+
+	if err := query.Order("id ASC").Find(&templates).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return templates, total, nil
+}
+
+// Update 修改模板，权益变化对引用该模板的卡密立即生效
+func (s *CardTemplateService) Update(id uint, req *UpdateCardTemplateRequest) (*models.CardTemplate, error) {
+	template, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			return nil, errors.New("模板名称不能为空")
+		}
+		template.Name = *req.Name
+	}
+	if req.Duration != nil {
+		template.Duration = *req.Duration
+	}
+	if req.CardType != nil {
+		template.CardType = *req.CardType
+	}
+	if req.MaxHWID != nil {
+		template.MaxHWID = *req.MaxHWID
+	}
+	if req.MaxIP != nil {
+		template.MaxIP = *req.MaxIP
+	}
+	if req.Features != nil {
+		template.Features = *req.Features
+	}
+	if req.Quotas != nil {
+		template.Quotas = *req.Quotas
+	}
+	if req.Meters != nil {
+		if err := validateMeters(*req.Meters); err != nil {
+			return nil, err
+		}
+		template.Meters = *req.Meters
+	}
+	if req.Note != nil {
+		template.Note = *req.Note
+	}
+
+	if err := database.DB.Save(template).Error; err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// Delete 删除模板及其模板级云变量，仍有卡密引用时拒绝，避免卡密权益被静默收回
+func (s *CardTemplateService) Delete(id uint) error {
+	var count int64
+	if err := database.DB.Model(&models.Card{}).Where("template_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("模板正在被 %d 个卡密使用", count)
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var cloudVars []models.CloudVar
+	if err := tx.Where("template_id = ?", id).Find(&cloudVars).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := deleteCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&models.CardTemplate{}, id).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// findProjectTemplate 查找模板并确认属于指定项目
+func findProjectTemplate(id, projectID uint) (*models.CardTemplate, error) {
+	var template models.CardTemplate
+	if err := database.DB.Where("id = ? AND project_id = ?", id, projectID).First(&template).Error; err != nil {
+		return nil, errors.New("模板不存在")
+	}
+	return &template, nil
+}
+
+// resolveCardEntitlements 返回卡密最终生效的权益，免费模式(card 为空)返回空权益
+func resolveCardEntitlements(card *models.Card) *models.Entitlements {
+	if card == nil {
+		return models.ResolveEntitlements(nil, nil)
+	}
+
+	var template *models.CardTemplate
+	if card.TemplateID != nil {
+		var t models.CardTemplate
+		if err := database.DB.First(&t, *card.TemplateID).Error; err == nil {
+			template = &t
+		}
+	}
+	return models.ResolveEntitlements(template, card)
+}
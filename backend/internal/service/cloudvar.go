@@ -1,10 +1,18 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/nextkey/nextkey/backend/internal/database"
 	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/jsonschema"
+	"github.com/nextkey/nextkey/backend/pkg/textdiff"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CloudVarService struct{}
@@ -13,50 +21,731 @@ func NewCloudVarService() *CloudVarService {
 	return &CloudVarService{}
 }
 
+// CreateCloudVarRequest TemplateID、CardID 均为空时设置项目级变量，二者只能填一个
 type CreateCloudVarRequest struct {
-	ProjectID uint   `json:"project_id"`
-	Key       string `json:"key"`
-	Value     string `json:"value"`
+	ProjectID      uint                 `json:"project_id"`
+	TemplateID     *uint                `json:"template_id"`
+	CardID         *uint                `json:"card_id"`
+	Key            string               `json:"key"`
+	Value          string               `json:"value"`
+	Type           string               `json:"type"`       // 为空时新变量使用 string，已有变量保持原类型和 schema
+	Schema         string               `json:"schema"`     // 仅 json 类型可用
+	Visibility     string               `json:"visibility"` // 为空时新变量使用 session，已有变量保持不变
+	AllowTemplates []uint               `json:"allow_templates"`
+	AllowFeatures  []string             `json:"allow_features"`
+	Rules          *models.RolloutRules `json:"rules"`      // 为空时保持原有规则，传 [] 清除
+	IfVersion      *int                 `json:"if_version"` // 仅当当前版本号等于该值时写入，新建变量传 0
 }
 
-func (s *CloudVarService) Set(req *CreateCloudVarRequest) (*models.CloudVar, error) {
+type RollbackCloudVarRequest struct {
+	Version   int  `json:"version"`
+	IfVersion *int `json:"if_version"`
+}
+
+// CloudVarDiff 两个版本的快照及逐行差异
+type CloudVarDiff struct {
+	From  *models.CloudVarVersion `json:"from"`
+	To    *models.CloudVarVersion `json:"to"`
+	Lines []textdiff.Line         `json:"lines"`
+}
+
+// CloudVarKeyError 批量写入时单个变量的错误
+type CloudVarKeyError struct {
+	Index int    `json:"index"`
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// CloudVarBatchError 批量写入校验失败，列出所有出错的变量，整批不写入
+type CloudVarBatchError struct {
+	Errors []CloudVarKeyError
+}
+
+func (e *CloudVarBatchError) Error() string {
+	return fmt.Sprintf("%d 个变量校验失败", len(e.Errors))
+}
+
+// ErrCloudVarConflict 写入时版本号与当前版本不一致
+var ErrCloudVarConflict = errors.New("变量已被修改，请刷新后重试")
+
+// 每个变量保留的历史版本数
+const cloudVarHistoryLimit = 100
+
+// cloudVarAuthor 写入者，记录在版本历史中
+type cloudVarAuthor struct {
+	adminID *uint
+	name    string
+	note    string
+}
+
+func adminAuthor(adminID uint) *cloudVarAuthor {
+	author := &cloudVarAuthor{adminID: &adminID}
+	var admin models.Admin
+	if err := database.DB.Unscoped().First(&admin, adminID).Error; err == nil {
+		author.name = admin.Username
+	}
+	return author
+}
+
+type SetCardVarRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type ListCloudVarsFilter struct {
+	ProjectID  uint
+	Scope      string // project/template/card/all，默认 project
+	TemplateID uint
+	CardID     uint
+	Key        string
+}
+
+// 卡密云变量键名的最大长度
+const cardVarMaxKeyLen = 64
+
+// scopedVars 按作用域筛选变量，模板和卡密均为空时为项目级变量
+func scopedVars(db *gorm.DB, projectID uint, templateID, cardID *uint) *gorm.DB {
+	query := db.Model(&models.CloudVar{}).Where("project_id = ?", projectID)
+	if templateID != nil {
+		query = query.Where("template_id = ?", *templateID)
+	} else {
+		query = query.Where("template_id IS NULL")
+	}
+	if cardID != nil {
+		query = query.Where("card_id = ?", *cardID)
+	} else {
+		query = query.Where("card_id IS NULL")
+	}
+	return query
+}
+
+// validateScope 确认模板或卡密属于变量所在项目
+func validateScope(req *CreateCloudVarRequest) error {
+	if req.Key == "" {
+		return errors.New("变量名不能为空")
+	}
+	if req.TemplateID != nil && req.CardID != nil {
+		return errors.New("模板和卡密只能指定一个")
+	}
+	if req.TemplateID != nil {
+		if _, err := findProjectTemplate(*req.TemplateID, req.ProjectID); err != nil {
+			return err
+		}
+	}
+	if req.CardID != nil {
+		var count int64
+		if err := database.DB.Model(&models.Card{}).Where("id = ? AND project_id = ?", *req.CardID, req.ProjectID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("卡密不存在")
+		}
+	}
+	if req.Type != "" && !models.ValidVarType(req.Type) {
+		return errors.New("类型须为 string/int/float/bool/json")
+	}
+	if req.Schema != "" {
+		if req.Type != models.VarTypeJSON {
+			return errors.New("只有 json 类型可以设置 schema")
+		}
+		if _, err := jsonschema.Compile(req.Schema); err != nil {
+			return err
+		}
+	}
+	if req.Visibility != "" && !models.ValidVisibility(req.Visibility) {
+		return errors.New("可见性须为 public/session/entitled/admin")
+	}
+	if req.Rules != nil {
+		if err := validateRules(req.ProjectID, *req.Rules); err != nil {
+			return err
+		}
+	}
+	if req.Visibility == models.VisibilityEntitled {
+		if len(req.AllowTemplates) == 0 && len(req.AllowFeatures) == 0 {
+			return errors.New("entitled 可见性须指定模板或功能")
+		}
+		for _, id := range req.AllowTemplates {
+			if _, err := findProjectTemplate(id, req.ProjectID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 单个变量的灰度规则数上限
+const cloudVarMaxRules = 20
+
+// validateRules 检查规则结构，值的类型在 applyType 中按变量类型校验
+func validateRules(projectID uint, rules models.RolloutRules) error {
+	if len(rules) > cloudVarMaxRules {
+		return fmt.Errorf("灰度规则不能超过 %d 条", cloudVarMaxRules)
+	}
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" || rule.Name == models.SegmentDefault {
+			return fmt.Errorf("第 %d 条规则: 名称不能为空或为 %s", i+1, models.SegmentDefault)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("规则名称 %s 重复", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Percent != nil && (*rule.Percent < 0 || *rule.Percent > 100) {
+			return fmt.Errorf("规则 %s: 比例须在 0-100 之间", rule.Name)
+		}
+		for _, id := range rule.Templates {
+			if _, err := findProjectTemplate(id, projectID); err != nil {
+				return fmt.Errorf("规则 %s: %v", rule.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func upsertCloudVar(db *gorm.DB, req *CreateCloudVarRequest, author *cloudVarAuthor) (*models.CloudVar, error) {
+	revision, err := nextVarRevision(db, req.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
 	var cloudVar models.CloudVar
-	err := database.DB.Where("project_id = ? AND key = ?", req.ProjectID, req.Key).First(&cloudVar).Error
+	err = scopedVars(db, req.ProjectID, req.TemplateID, req.CardID).Where("key = ?", req.Key).First(&cloudVar).Error
 
 	if err != nil {
+		// 变量不存在时 if_version 只能为 0
+		if req.IfVersion != nil && *req.IfVersion != 0 {
+			return nil, ErrCloudVarConflict
+		}
 		cloudVar = models.CloudVar{
-			ProjectID: req.ProjectID,
-			Key:       req.Key,
-			Value:     req.Value,
+			ProjectID:  req.ProjectID,
+			TemplateID: req.TemplateID,
+			CardID:     req.CardID,
+			Key:        req.Key,
+			Value:      req.Value,
+			Type:       models.VarTypeString,
+			Visibility: models.VisibilitySession,
+			Version:    1,
+			Revision:   revision,
+			Rules:      models.RolloutRules{},
+		}
+		applyVisibility(&cloudVar, req)
+		applyRules(&cloudVar, req)
+		if err := applyType(&cloudVar, req); err != nil {
+			return nil, err
 		}
-		if err := database.DB.Create(&cloudVar).Error; err != nil {
+		if err := db.Create(&cloudVar).Error; err != nil {
 			return nil, err
 		}
 	} else {
+		if req.IfVersion != nil && *req.IfVersion != cloudVar.Version {
+			return nil, ErrCloudVarConflict
+		}
+		current := cloudVar.Version
 		cloudVar.Value = req.Value
-		if err := database.DB.Save(&cloudVar).Error; err != nil {
+		cloudVar.Version++
+		cloudVar.Revision = revision
+		applyVisibility(&cloudVar, req)
+		applyRules(&cloudVar, req)
+		if err := applyType(&cloudVar, req); err != nil {
 			return nil, err
 		}
+		// 按读取时的版本号更新，并发写入时只有一个成功
+		result := db.Model(&cloudVar).Where("version = ?", current).
+			Select("value", "type", "schema", "visibility", "allow_templates", "allow_features", "rules", "version", "revision", "updated_at").
+			Updates(&cloudVar)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrCloudVarConflict
+		}
+	}
+
+	if err := recordCloudVarVersion(db, &cloudVar, author); err != nil {
+		return nil, err
 	}
 
 	return &cloudVar, nil
 }
 
-func (s *CloudVarService) Get(projectID uint, key string) (*models.CloudVar, error) {
+// nextVarRevision 递增项目的云变量修订号，须在写入变量的事务内调用，
+// 计数行的行锁使并发事务按提交顺序取得修订号
+func nextVarRevision(db *gorm.DB, projectID uint) (int64, error) {
+	counter := models.CloudVarRevision{ProjectID: projectID}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.CloudVarRevision{}).Where("project_id = ?", projectID).
+		UpdateColumn("revision", gorm.Expr("revision + 1")).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Where("project_id = ?", projectID).First(&counter).Error; err != nil {
+		return 0, err
+	}
+	return counter.Revision, nil
+}
+
+func currentVarRevision(projectID uint) (int64, error) {
+	var counter models.CloudVarRevision
+	err := database.DB.Where("project_id = ?", projectID).Limit(1).Find(&counter).Error
+	return counter.Revision, err
+}
+
+// deleteCloudVars 软删除变量前写入新的修订号，使变更订阅能感知删除
+func deleteCloudVars(db *gorm.DB, cloudVars []models.CloudVar) error {
+	byProject := make(map[uint][]uint)
+	for _, v := range cloudVars {
+		byProject[v.ProjectID] = append(byProject[v.ProjectID], v.ID)
+	}
+	for projectID, ids := range byProject {
+		revision, err := nextVarRevision(db, projectID)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&models.CloudVar{}).Where("id IN ?", ids).UpdateColumn("revision", revision).Error; err != nil {
+			return err
+		}
+		if err := db.Where("id IN ?", ids).Delete(&models.CloudVar{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCloudVars 从回收站恢复变量，同样写入新的修订号，使变更订阅重新下发
+func restoreCloudVars(db *gorm.DB, cloudVars []models.CloudVar) error {
+	byProject := make(map[uint][]uint)
+	for _, v := range cloudVars {
+		byProject[v.ProjectID] = append(byProject[v.ProjectID], v.ID)
+	}
+	for projectID, ids := range byProject {
+		revision, err := nextVarRevision(db, projectID)
+		if err != nil {
+			return err
+		}
+		if err := db.Unscoped().Model(&models.CloudVar{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"deleted_at": nil, "revision": revision}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordCloudVarVersion 保存写入后的快照，并清理超出保留数量的旧版本
+func recordCloudVarVersion(db *gorm.DB, cloudVar *models.CloudVar, author *cloudVarAuthor) error {
+	version := models.CloudVarVersion{
+		CloudVarID:     cloudVar.ID,
+		Version:        cloudVar.Version,
+		Revision:       cloudVar.Revision,
+		Value:          cloudVar.Value,
+		Type:           cloudVar.Type,
+		Schema:         cloudVar.Schema,
+		Visibility:     cloudVar.Visibility,
+		AllowTemplates: cloudVar.AllowTemplates,
+		AllowFeatures:  cloudVar.AllowFeatures,
+		Rules:          cloudVar.Rules,
+		AdminID:        author.adminID,
+		Author:         author.name,
+		Note:           author.note,
+	}
+	if err := db.Create(&version).Error; err != nil {
+		return err
+	}
+	return db.Where("cloud_var_id = ? AND version <= ?", cloudVar.ID, cloudVar.Version-cloudVarHistoryLimit).
+		Delete(&models.CloudVarVersion{}).Error
+}
+
+// applyType 设置类型并校验值，类型为空时沿用变量原有类型
+func applyType(cloudVar *models.CloudVar, req *CreateCloudVarRequest) error {
+	if req.Type != "" {
+		cloudVar.Type = req.Type
+		cloudVar.Schema = req.Schema
+	}
+	if cloudVar.Type == "" {
+		cloudVar.Type = models.VarTypeString
+	}
+	if err := validateVarValue(cloudVar.Type, cloudVar.Schema, cloudVar.Value); err != nil {
+		return err
+	}
+	for _, rule := range cloudVar.Rules {
+		if err := validateVarValue(cloudVar.Type, cloudVar.Schema, rule.Value); err != nil {
+			return fmt.Errorf("规则 %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateVarValue(typ, schema, value string) error {
+	if _, err := models.ParseVarValue(typ, value); err != nil {
+		return fmt.Errorf("值与类型 %s 不符: %v", typ, err)
+	}
+	if schema == "" {
+		return nil
+	}
+	compiled, err := jsonschema.Compile(schema)
+	if err != nil {
+		return err
+	}
+	if err := compiled.Validate([]byte(value)); err != nil {
+		return fmt.Errorf("值不符合 schema: %v", err)
+	}
+	return nil
+}
+
+// checkCloudVar 写入前按变量当前的类型校验，不修改数据
+func checkCloudVar(req *CreateCloudVarRequest) error {
+	if err := validateScope(req); err != nil {
+		return err
+	}
 	var cloudVar models.CloudVar
-	if err := database.DB.Where("project_id = ? AND key = ?", projectID, key).First(&cloudVar).Error; err != nil {
+	if err := scopedVars(database.DB, req.ProjectID, req.TemplateID, req.CardID).Where("key = ?", req.Key).First(&cloudVar).Error; err != nil {
+		cloudVar = models.CloudVar{}
+	}
+	cloudVar.Value = req.Value
+	applyRules(&cloudVar, req)
+	return applyType(&cloudVar, req)
+}
+
+func applyVisibility(cloudVar *models.CloudVar, req *CreateCloudVarRequest) {
+	if req.Visibility == "" {
+		return
+	}
+	cloudVar.Visibility = req.Visibility
+	cloudVar.AllowTemplates = models.UintArray{}
+	cloudVar.AllowFeatures = models.StringArray{}
+	if req.Visibility == models.VisibilityEntitled {
+		cloudVar.AllowTemplates = req.AllowTemplates
+		cloudVar.AllowFeatures = req.AllowFeatures
+	}
+}
+
+func applyRules(cloudVar *models.CloudVar, req *CreateCloudVarRequest) {
+	if req.Rules != nil {
+		cloudVar.Rules = *req.Rules
+	}
+}
+
+// Set 管理员写入变量，adminID 记录在版本历史中
+func (s *CloudVarService) Set(req *CreateCloudVarRequest, adminID uint) (*models.CloudVar, error) {
+	if err := validateScope(req); err != nil {
+		return nil, err
+	}
+	return writeCloudVar(req, adminAuthor(adminID))
+}
+
+// writeCloudVar 在事务中写入变量和版本快照
+func writeCloudVar(req *CreateCloudVarRequest, author *cloudVarAuthor) (*models.CloudVar, error) {
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	cloudVar, err := upsertCloudVar(tx, req, author)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return cloudVar, nil
+}
+
+// varReader 读取变量的客户端会话，未登录时各字段为空，免费模式 card 为空
+type varReader struct {
+	card          *models.Card
+	hwid          string
+	clientVersion string
+}
+
+func newVarReader(token *models.Token) (*varReader, error) {
+	reader := &varReader{}
+	if token == nil {
+		return reader, nil
+	}
+	reader.hwid = token.HWID
+	reader.clientVersion = token.ClientVersion
+	if token.CardID != nil {
+		reader.card = &models.Card{}
+		if err := database.DB.First(reader.card, *token.CardID).Error; err != nil {
+			return nil, errors.New("卡密不存在")
+		}
+	}
+	return reader, nil
+}
+
+func (r *varReader) subject() *models.RolloutSubject {
+	subject := &models.RolloutSubject{HWID: r.hwid, ClientVersion: r.clientVersion}
+	if r.card != nil {
+		subject.CardID = r.card.ID
+		subject.TemplateID = r.card.TemplateID
+	}
+	return subject
+}
+
+// readableScopes 限定为卡密能读取到的作用域，无卡密时只有项目级变量
+func readableScopes(query *gorm.DB, card *models.Card) *gorm.DB {
+	if card == nil {
+		return query.Where("card_id IS NULL AND template_id IS NULL")
+	}
+	var templateID uint
+	if card.TemplateID != nil {
+		templateID = *card.TemplateID
+	}
+	return query.Where("(card_id = ? OR (card_id IS NULL AND (template_id IS NULL OR template_id = ?)))", card.ID, templateID)
+}
+
+// visibleVars 按 卡密 > 模板 > 项目 的顺序解析客户端可见的变量并应用灰度规则，keys 为空时返回全部
+func visibleVars(projectID uint, reader *varReader, keys []string) (map[string]*models.CloudVar, error) {
+	card := reader.card
+	query := database.DB.Where("project_id = ?", projectID)
+	if len(keys) > 0 {
+		query = query.Where("key IN ?", keys)
+	}
+
+	var candidates []models.CloudVar
+	if err := readableScopes(query, card).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	features := resolveCardEntitlements(card).Features
+	priority := map[string]int{"card": 3, "template": 2, "project": 1}
+	resolved := make(map[string]*models.CloudVar)
+	for i := range candidates {
+		v := &candidates[i]
+		// 不可见的变量视为不存在，继续使用低优先级的值
+		if !v.VisibleTo(card, features) {
+			continue
+		}
+		if found, ok := resolved[v.Key]; !ok || priority[v.Scope()] > priority[found.Scope()] {
+			resolved[v.Key] = v
+		}
+	}
+	subject := reader.subject()
+	for _, v := range resolved {
+		v.ApplyRollout(subject)
+		v.FillTypedValue()
+	}
+	return resolved, nil
+}
+
+// Get 按 卡密 > 模板 > 项目 的顺序查找客户端可见的变量，未登录(token 为空)或免费模式只能读取公开的项目级变量
+func (s *CloudVarService) Get(projectID uint, token *models.Token, key string) (*models.CloudVar, error) {
+	reader, err := newVarReader(token)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := visibleVars(projectID, reader, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	found, ok := resolved[key]
+	if !ok {
 		return nil, errors.New("变量不存在")
 	}
-	return &cloudVar, nil
+	return found, nil
+}
+
+// 单次批量读取的变量名上限
+const cloudVarBulkMaxKeys = 200
+
+type BulkGetCloudVarsRequest struct {
+	Keys []string `json:"keys"` // 为空时返回全部可见变量
+	ETag string   `json:"etag"` // 上次响应的 etag，变量未变化时不再返回内容
+}
+
+// CloudVarBundle 批量读取结果，vars 中不包含不存在或不可见的变量
+type CloudVarBundle struct {
+	Vars        map[string]*models.CloudVar `json:"vars"`
+	ETag        string                      `json:"etag"`
+	Revision    int64                       `json:"revision"` // 作为变更接口的 since
+	NotModified bool                        `json:"not_modified"`
+}
+
+// CloudVarChanges since 之后客户端可见结果发生变化的变量
+type CloudVarChanges struct {
+	Revision int64                       `json:"revision"`
+	Changed  map[string]*models.CloudVar `json:"changed"`
+	Deleted  []string                    `json:"deleted"` // 已删除或不再可见的变量名
+	Reset    bool                        `json:"reset"`   // since 超过当前修订号，客户端应重新批量读取
+}
+
+// varsETag 由变量名、ID、版本号和命中的分组计算，任一变量新增、删除、改写或换组都会改变
+func varsETag(vars map[string]*models.CloudVar) string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s\n", key, vars[key].ID, vars[key].Version, vars[key].Segment)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
 }
 
-func (s *CloudVarService) List(projectID uint, page, pageSize int) ([]models.CloudVar, int64, error) {
+// BulkGet 一次读取多个变量，etag 与当前结果一致时只返回 not_modified
+func (s *CloudVarService) BulkGet(projectID uint, token *models.Token, req *BulkGetCloudVarsRequest) (*CloudVarBundle, error) {
+	if len(req.Keys) > cloudVarBulkMaxKeys {
+		return nil, fmt.Errorf("单次最多读取 %d 个变量", cloudVarBulkMaxKeys)
+	}
+	reader, err := newVarReader(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先取修订号再读变量，之后的写入一定会出现在下一次变更查询中
+	revision, err := currentVarRevision(projectID)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := visibleVars(projectID, reader, req.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &CloudVarBundle{ETag: varsETag(vars), Revision: revision}
+	if req.ETag != "" && req.ETag == bundle.ETag {
+		bundle.NotModified = true
+		return bundle, nil
+	}
+	bundle.Vars = vars
+	return bundle, nil
+}
+
+// Changes 返回修订号 since 之后可能变化的变量，按客户端当前可见的结果给出新值或标记删除
+func (s *CloudVarService) Changes(projectID uint, token *models.Token, since int64) (*CloudVarChanges, error) {
+	reader, err := newVarReader(token)
+	if err != nil {
+		return nil, err
+	}
+	return varChanges(projectID, reader, since)
+}
+
+func varChanges(projectID uint, reader *varReader, since int64) (*CloudVarChanges, error) {
+	revision, err := currentVarRevision(projectID)
+	if err != nil {
+		return nil, err
+	}
+	changes := &CloudVarChanges{
+		Revision: revision,
+		Changed:  map[string]*models.CloudVar{},
+		Deleted:  []string{},
+	}
+	if since > revision {
+		changes.Reset = true
+		return changes, nil
+	}
+	if since == revision {
+		return changes, nil
+	}
+
+	// 包含已软删除的变量，删除时也会写入修订号
+	var rows []models.CloudVar
+	query := database.DB.Unscoped().Where("project_id = ? AND revision > ?", projectID, since)
+	if err := readableScopes(query, reader.card).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// 只处理该会话现在可见或 since 时可见的变量，避免泄露从未可见的变量名称
+	features := resolveCardEntitlements(reader.card).Features
+	seen := make(map[string]bool)
+	keys := []string{}
+	hidden := []uint{}
+	for i := range rows {
+		if !rows[i].VisibleTo(reader.card, features) {
+			hidden = append(hidden, rows[i].ID)
+			continue
+		}
+		if !seen[rows[i].Key] {
+			seen[rows[i].Key] = true
+			keys = append(keys, rows[i].Key)
+		}
+	}
+	wasVisible, err := visibleAtRevision(hidden, reader.card, features, since)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if wasVisible[rows[i].ID] && !seen[rows[i].Key] {
+			seen[rows[i].Key] = true
+			keys = append(keys, rows[i].Key)
+		}
+	}
+	if len(keys) == 0 {
+		return changes, nil
+	}
+
+	vars, err := visibleVars(projectID, reader, keys)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if v, ok := vars[key]; ok {
+			changes.Changed[key] = v
+		} else {
+			changes.Deleted = append(changes.Deleted, key)
+		}
+	}
+	return changes, nil
+}
+
+// visibleAtRevision 按修订号 since 时的版本快照判断变量当时是否对该会话可见，
+// 用于改为管理员可见或收回权益后下发删除标记；快照已被清理的变量视为不可见
+func visibleAtRevision(ids []uint, card *models.Card, features []string, since int64) (map[uint]bool, error) {
+	visible := make(map[uint]bool)
+	if len(ids) == 0 || since <= 0 {
+		return visible, nil
+	}
+
+	var versions []models.CloudVarVersion
+	if err := database.DB.Where("cloud_var_id IN ? AND revision <= ?", ids, since).
+		Order("cloud_var_id ASC, version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	checked := make(map[uint]bool)
+	for _, v := range versions {
+		if checked[v.CloudVarID] {
+			continue
+		}
+		checked[v.CloudVarID] = true
+		snapshot := models.CloudVar{Visibility: v.Visibility, AllowTemplates: v.AllowTemplates, AllowFeatures: v.AllowFeatures}
+		visible[v.CloudVarID] = snapshot.VisibleTo(card, features)
+	}
+	return visible, nil
+}
+
+func (s *CloudVarService) List(filter *ListCloudVarsFilter, page, pageSize int) ([]models.CloudVar, int64, error) {
 	var cloudVars []models.CloudVar
 	var total int64
 
 	query := database.DB.Model(&models.CloudVar{})
-	if projectID > 0 {
-		query = query.Where("project_id = ?", projectID)
+	if filter.ProjectID > 0 {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.CardID > 0 {
+		query = query.Where("card_id = ?", filter.CardID)
+	} else if filter.TemplateID > 0 {
+		query = query.Where("template_id = ?", filter.TemplateID)
+	} else {
+		switch filter.Scope {
+		case "all":
+		case "template":
+			query = query.Where("template_id IS NOT NULL")
+		case "card":
+			query = query.Where("card_id IS NOT NULL")
+		default:
+			query = query.Where("template_id IS NULL AND card_id IS NULL")
+		}
+	}
+	if filter.Key != "" {
+		query = query.Where("key LIKE ? ESCAPE '\\'", "%"+escapeLikeString(filter.Key)+"%")
 	}
 
 	if err := query.Count(&total).Error; err != nil {
@@ -76,13 +765,24 @@ func (s *CloudVarService) List(projectID uint, page, pageSize int) ([]models.Clo
 }
 
 func (s *CloudVarService) Delete(id uint) error {
-	return database.DB.Delete(&models.CloudVar{}, id).Error
+	return s.BatchDelete([]uint{id})
 }
 
-func (s *CloudVarService) BatchSet(reqs []CreateCloudVarRequest) error {
+func (s *CloudVarService) BatchSet(reqs []CreateCloudVarRequest, adminID uint) error {
 	if len(reqs) == 0 {
 		return errors.New("未提供变量数据")
 	}
+	batchErr := &CloudVarBatchError{}
+	for i := range reqs {
+		if err := checkCloudVar(&reqs[i]); err != nil {
+			batchErr.Errors = append(batchErr.Errors, CloudVarKeyError{Index: i, Key: reqs[i].Key, Error: err.Error()})
+		}
+	}
+	if len(batchErr.Errors) > 0 {
+		return batchErr
+	}
+
+	author := adminAuthor(adminID)
 
 	tx := database.DB.Begin()
 	defer func() {
@@ -91,26 +791,13 @@ func (s *CloudVarService) BatchSet(reqs []CreateCloudVarRequest) error {
 		}
 	}()
 
-	for _, req := range reqs {
-		var cloudVar models.CloudVar
-		err := tx.Where("project_id = ? AND key = ?", req.ProjectID, req.Key).First(&cloudVar).Error
-
-		if err != nil {
-			cloudVar = models.CloudVar{
-				ProjectID: req.ProjectID,
-				Key:       req.Key,
-				Value:     req.Value,
-			}
-			if err := tx.Create(&cloudVar).Error; err != nil {
-				tx.Rollback()
-				return err
-			}
-		} else {
-			cloudVar.Value = req.Value
-			if err := tx.Save(&cloudVar).Error; err != nil {
-				tx.Rollback()
-				return err
+	for i := range reqs {
+		if _, err := upsertCloudVar(tx, &reqs[i], author); err != nil {
+			tx.Rollback()
+			if errors.Is(err, ErrCloudVarConflict) {
+				return fmt.Errorf("%s: %w", reqs[i].Key, err)
 			}
+			return err
 		}
 	}
 
@@ -129,10 +816,317 @@ func (s *CloudVarService) BatchDelete(ids []uint) error {
 		}
 	}()
 
-	if err := tx.Where("id IN ?", ids).Delete(&models.CloudVar{}).Error; err != nil {
+	var cloudVars []models.CloudVar
+	if err := tx.Where("id IN ?", ids).Find(&cloudVars).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := deleteCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// ListCardVars 返回卡密级变量中客户端可见的部分
+func (s *CloudVarService) ListCardVars(token *models.Token) ([]models.CloudVar, error) {
+	reader, err := newVarReader(token)
+	if err != nil {
+		return nil, err
+	}
+	if reader.card == nil {
+		return nil, errors.New("卡密不存在")
+	}
+
+	var cloudVars []models.CloudVar
+	if err := database.DB.Where("card_id = ?", reader.card.ID).Order("key ASC").Find(&cloudVars).Error; err != nil {
+		return nil, err
+	}
+
+	features := resolveCardEntitlements(reader.card).Features
+	subject := reader.subject()
+	visible := make([]models.CloudVar, 0, len(cloudVars))
+	for _, v := range cloudVars {
+		if v.VisibleTo(reader.card, features) {
+			v.ApplyRollout(subject)
+			v.FillTypedValue()
+			visible = append(visible, v)
+		}
+	}
+	return visible, nil
+}
+
+// SetCardVar 客户端写入卡密级变量，受项目的个数和大小上限约束
+func (s *CloudVarService) SetCardVar(cardID uint, req *SetCardVarRequest) (*models.CloudVar, error) {
+	if req.Key == "" {
+		return nil, errors.New("变量名不能为空")
+	}
+	if len(req.Key) > cardVarMaxKeyLen {
+		return nil, fmt.Errorf("变量名不能超过 %d 个字符", cardVarMaxKeyLen)
+	}
+
+	var card models.Card
+	if err := database.DB.Preload("Project").First(&card, cardID).Error; err != nil {
+		return nil, errors.New("卡密不存在")
+	}
+	if card.Project == nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	if len(req.Value) > card.Project.CardVarMaxSize {
+		return nil, fmt.Errorf("变量值不能超过 %d 字节", card.Project.CardVarMaxSize)
+	}
+	features := resolveCardEntitlements(&card).Features
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var existing []models.CloudVar
+	if err := tx.Where("card_id = ? AND key = ?", cardID, req.Key).Find(&existing).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	// 管理员设置的、客户端不可见的变量不能被覆盖
+	if len(existing) > 0 && !existing[0].VisibleTo(&card, features) {
+		tx.Rollback()
+		return nil, errors.New("该变量由管理员维护，不能修改")
+	}
+	if len(existing) == 0 {
+		var count int64
+		if err := tx.Model(&models.CloudVar{}).Where("card_id = ?", cardID).Count(&count).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if count >= int64(card.Project.CardVarMaxCount) {
+			tx.Rollback()
+			return nil, fmt.Errorf("变量个数已达上限 %d", card.Project.CardVarMaxCount)
+		}
+	}
+
+	cloudVar, err := upsertCloudVar(tx, &CreateCloudVarRequest{
+		ProjectID: card.ProjectID,
+		CardID:    &card.ID,
+		Key:       req.Key,
+		Value:     req.Value,
+	}, &cloudVarAuthor{name: "client"})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// 管理员设置的灰度规则不返回给客户端
+	cloudVar.Rules = nil
+	return cloudVar, nil
+}
+
+// DeleteCardVar 客户端删除卡密级变量，只能删除自己的且非仅后台可见的变量
+func (s *CloudVarService) DeleteCardVar(cardID uint, key string) error {
+	var card models.Card
+	if err := database.DB.First(&card, cardID).Error; err != nil {
+		return errors.New("卡密不存在")
+	}
+	features := resolveCardEntitlements(&card).Features
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var cloudVars []models.CloudVar
+	if err := tx.Where("card_id = ? AND key = ?", cardID, key).Find(&cloudVars).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 与读取和写入一致，客户端不可见的变量视为不存在
+	if len(cloudVars) == 0 || !cloudVars[0].VisibleTo(&card, features) {
+		tx.Rollback()
+		return errors.New("变量不存在")
+	}
+	if err := deleteCloudVars(tx, cloudVars); err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	return tx.Commit().Error
 }
+
+func (s *CloudVarService) ListVersions(cloudVarID uint, page, pageSize int) ([]models.CloudVarVersion, int64, error) {
+	var versions []models.CloudVarVersion
+	var total int64
+
+	query := database.DB.Model(&models.CloudVarVersion{}).Where("cloud_var_id = ?", cloudVarID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+
+	if err := query.Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return versions, total, nil
+}
+
+func (s *CloudVarService) getVersion(cloudVarID uint, version int) (*models.CloudVarVersion, error) {
+	var v models.CloudVarVersion
+	if err := database.DB.Where("cloud_var_id = ? AND version = ?", cloudVarID, version).First(&v).Error; err != nil {
+		return nil, fmt.Errorf("版本 %d 不存在", version)
+	}
+	return &v, nil
+}
+
+// Diff 比较两个历史版本的值
+func (s *CloudVarService) Diff(cloudVarID uint, from, to int) (*CloudVarDiff, error) {
+	fromVersion, err := s.getVersion(cloudVarID, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := s.getVersion(cloudVarID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CloudVarDiff{
+		From:  fromVersion,
+		To:    toVersion,
+		Lines: textdiff.Lines(fromVersion.Value, toVersion.Value),
+	}, nil
+}
+
+// Rollback 以历史版本的值和可见性写入一个新版本，不改写历史
+func (s *CloudVarService) Rollback(cloudVarID uint, req *RollbackCloudVarRequest, adminID uint) (*models.CloudVar, error) {
+	var cloudVar models.CloudVar
+	if err := database.DB.First(&cloudVar, cloudVarID).Error; err != nil {
+		return nil, errors.New("变量不存在")
+	}
+	target, err := s.getVersion(cloudVarID, req.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	ifVersion := cloudVar.Version
+	if req.IfVersion != nil {
+		ifVersion = *req.IfVersion
+	}
+	author := adminAuthor(adminID)
+	author.note = fmt.Sprintf("回滚到版本 %d", target.Version)
+
+	return writeCloudVar(&CreateCloudVarRequest{
+		ProjectID:      cloudVar.ProjectID,
+		TemplateID:     cloudVar.TemplateID,
+		CardID:         cloudVar.CardID,
+		Key:            cloudVar.Key,
+		Value:          target.Value,
+		Type:           target.Type,
+		Schema:         target.Schema,
+		Visibility:     target.Visibility,
+		AllowTemplates: target.AllowTemplates,
+		AllowFeatures:  target.AllowFeatures,
+		Rules:          &target.Rules,
+		IfVersion:      &ifVersion,
+	}, author)
+}
+
+// CloudVarSegment 卡密在变量灰度规则下命中的分组
+type CloudVarSegment struct {
+	CardID        uint   `json:"card_id"`
+	CardKey       string `json:"card_key"`
+	TemplateID    *uint  `json:"template_id"`
+	HWID          string `json:"hwid"`           // 最近一次登录的设备码
+	ClientVersion string `json:"client_version"` // 最近一次登录上报的客户端版本
+	Bucket        int    `json:"bucket"`         // 按比例灰度时的分桶(0-99)
+	Rule          int    `json:"rule"`           // 命中的规则下标，-1 为默认值
+	Segment       string `json:"segment"`
+	Value         string `json:"value"`
+}
+
+// Segments 列出变量作用范围内的卡密命中的分组，cardID 不为 0 时只查该卡密
+func (s *CloudVarService) Segments(cloudVarID, cardID uint, page, pageSize int) ([]CloudVarSegment, int64, error) {
+	var cloudVar models.CloudVar
+	if err := database.DB.First(&cloudVar, cloudVarID).Error; err != nil {
+		return nil, 0, errors.New("变量不存在")
+	}
+
+	query := database.DB.Model(&models.Card{}).Where("project_id = ?", cloudVar.ProjectID)
+	switch {
+	case cloudVar.CardID != nil:
+		query = query.Where("id = ?", *cloudVar.CardID)
+	case cloudVar.TemplateID != nil:
+		query = query.Where("template_id = ?", *cloudVar.TemplateID)
+	}
+	if cardID > 0 {
+		query = query.Where("id = ?", cardID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+	var cards []models.Card
+	if err := query.Order("id ASC").Find(&cards).Error; err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]uint, len(cards))
+	for i, card := range cards {
+		ids[i] = card.ID
+	}
+	// 每张卡密只取最近一次登录的 Token，包含已删除的
+	latestIDs := database.DB.Unscoped().Model(&models.Token{}).Select("MAX(id)").Where("card_id IN ?", ids).Group("card_id")
+	var tokens []models.Token
+	if err := database.DB.Unscoped().Where("id IN (?)", latestIDs).Find(&tokens).Error; err != nil {
+		return nil, 0, err
+	}
+	latest := make(map[uint]models.Token, len(tokens))
+	for _, token := range tokens {
+		latest[*token.CardID] = token
+	}
+
+	segments := make([]CloudVarSegment, len(cards))
+	for i, card := range cards {
+		token := latest[card.ID]
+		subject := &models.RolloutSubject{
+			CardID:        card.ID,
+			TemplateID:    card.TemplateID,
+			HWID:          token.HWID,
+			ClientVersion: token.ClientVersion,
+		}
+		segment := CloudVarSegment{
+			CardID:        card.ID,
+			CardKey:       card.CardKey,
+			TemplateID:    card.TemplateID,
+			HWID:          token.HWID,
+			ClientVersion: token.ClientVersion,
+			Bucket:        models.RolloutBucket(cloudVar.Key, card.ID),
+			Rule:          cloudVar.MatchRule(subject),
+			Segment:       models.SegmentDefault,
+			Value:         cloudVar.Value,
+		}
+		if segment.Rule >= 0 {
+			segment.Segment = cloudVar.Rules[segment.Rule].Name
+			segment.Value = cloudVar.Rules[segment.Rule].Value
+		}
+		segments[i] = segment
+	}
+
+	return segments, total, nil
+}
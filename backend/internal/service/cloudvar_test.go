@@ -0,0 +1,128 @@
+package service
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/config"
+)
+
+// setupTestDB 在临时目录中初始化数据库
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Admin.Username = "admin"
+	cfg.Admin.Password = "admin123"
+	if err := database.Initialize(filepath.Join(t.TempDir(), "test.db"), cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupVarReader 创建付费项目和一张引用带 vip 功能模板的卡密
+func setupVarReader(t *testing.T) (*models.Project, *varReader) {
+	t.Helper()
+	setupTestDB(t)
+
+	project, err := NewProjectService().Create(&CreateProjectRequest{Name: "p", Mode: "paid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := NewCardTemplateService().Create(&CreateCardTemplateRequest{ProjectID: project.ID, Name: "vip", Duration: 3600, Features: models.StringArray{"vip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err := NewCardService().CreateBatch(&CreateCardRequest{ProjectID: project.ID, Count: 1, Duration: 3600, TemplateID: &template.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project, &varReader{card: &cards[0]}
+}
+
+func setTestVar(t *testing.T, req *CreateCloudVarRequest) {
+	t.Helper()
+	if _, err := NewCloudVarService().Set(req, 1); err != nil {
+		t.Fatalf("Set %s: %v", req.Key, err)
+	}
+}
+
+func TestVarChangesHiddenAfterSince(t *testing.T) {
+	project, reader := setupVarReader(t)
+
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "session", Value: "1", Visibility: models.VisibilitySession})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "entitled", Value: "1", Visibility: models.VisibilityEntitled, AllowFeatures: []string{"vip"}})
+	since, err := currentVarRevision(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 改为管理员可见、收回权益，以及新建一个从未可见的变量
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "session", Value: "2", Visibility: models.VisibilityAdmin})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "entitled", Value: "2", Visibility: models.VisibilityEntitled, AllowFeatures: []string{"gold"}})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "admin", Value: "1", Visibility: models.VisibilityAdmin})
+
+	changes, err := varChanges(project.ID, reader, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Changed) != 0 {
+		t.Fatalf("Changed = %v, want empty", changes.Changed)
+	}
+	if want := []string{"entitled", "session"}; !reflect.DeepEqual(changes.Deleted, want) {
+		t.Fatalf("Deleted = %v, want %v", changes.Deleted, want)
+	}
+
+	// 从头同步时这些变量从未可见，不能出现在结果中
+	changes, err = varChanges(project.ID, reader, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 0 {
+		t.Fatalf("changes since 0 = %v / %v, want empty", changes.Changed, changes.Deleted)
+	}
+}
+
+func TestVarChangesPublicReader(t *testing.T) {
+	project, _ := setupVarReader(t)
+
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "public", Value: "1", Visibility: models.VisibilityPublic})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "session", Value: "1", Visibility: models.VisibilitySession})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "admin", Value: "1", Visibility: models.VisibilityAdmin})
+
+	changes, err := varChanges(project.ID, &varReader{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := changes.Changed["public"]; !ok || len(changes.Changed) != 1 || len(changes.Deleted) != 0 {
+		t.Fatalf("changes = %v / %v, want only public", changes.Changed, changes.Deleted)
+	}
+}
+
+func TestSegmentsUseLatestToken(t *testing.T) {
+	project, reader := setupVarReader(t)
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "v", Value: "1"})
+	var cloudVar models.CloudVar
+	if err := database.DB.Where("project_id = ? AND key = ?", project.ID, "v").First(&cloudVar).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cardID := reader.card.ID
+	for _, version := range []string{"1.0.0", "1.2.0"} {
+		if _, err := createToken(project, &cardID, "hw-"+version, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, total, err := NewCloudVarService().Segments(cloudVar.ID, 0, 1, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(segments) != 1 {
+		t.Fatalf("segments = %v (total %d), want 1", segments, total)
+	}
+	if segments[0].ClientVersion != "1.2.0" || segments[0].HWID != "hw-1.2.0" {
+		t.Fatalf("segment = %+v, want latest token", segments[0])
+	}
+}
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/textdiff"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// 导出文件格式版本
+const cloudVarExportVersion = 1
+
+// 导入冲突处理方式
+const (
+	ImportConflictOverwrite = "overwrite"
+	ImportConflictSkip      = "skip"
+	ImportConflictFail      = "fail"
+)
+
+// ErrCloudVarImportConflict conflict 为 fail 时目标项目存在内容不同的同名变量
+var ErrCloudVarImportConflict = errors.New("目标项目存在冲突的变量，未导入")
+
+// CloudVarExport 项目级和模板级变量的导出文件，模板以名称引用，卡密级变量不导出
+type CloudVarExport struct {
+	Version    int                  `json:"version" yaml:"version"`
+	Project    string               `json:"project" yaml:"project"`
+	ExportedAt time.Time            `json:"exported_at" yaml:"exported_at"`
+	Vars       []CloudVarExportItem `json:"vars" yaml:"vars"`
+
+	// Skipped 引用的模板已删除、无法导入而未导出的变量，不写入文件
+	Skipped []string `json:"-" yaml:"-"`
+}
+
+type CloudVarExportItem struct {
+	Key            string               `json:"key" yaml:"key"`
+	Template       string               `json:"template,omitempty" yaml:"template,omitempty"` // 为空时为项目级变量
+	Type           string               `json:"type" yaml:"type"`
+	Value          string               `json:"value" yaml:"value"`
+	Schema         string               `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Visibility     string               `json:"visibility" yaml:"visibility"`
+	AllowTemplates []string             `json:"allow_templates,omitempty" yaml:"allow_templates,omitempty"`
+	AllowFeatures  []string             `json:"allow_features,omitempty" yaml:"allow_features,omitempty"`
+	Rules          []CloudVarExportRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// CloudVarExportRule 同 models.RolloutRule，模板以名称引用
+type CloudVarExportRule struct {
+	Name       string   `json:"name" yaml:"name"`
+	Value      string   `json:"value" yaml:"value"`
+	Percent    *int     `json:"percent,omitempty" yaml:"percent,omitempty"`
+	Templates  []string `json:"templates,omitempty" yaml:"templates,omitempty"`
+	HWIDs      []string `json:"hwids,omitempty" yaml:"hwids,omitempty"`
+	MinVersion string   `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	MaxVersion string   `json:"max_version,omitempty" yaml:"max_version,omitempty"`
+}
+
+type ImportCloudVarsRequest struct {
+	ProjectID uint   `json:"project_id"`
+	Content   string `json:"content"`  // 导出的 JSON 或 YAML 文本
+	Conflict  string `json:"conflict"` // overwrite/skip/fail，默认 fail
+	DryRun    bool   `json:"dry_run"`  // 只返回差异，不写入
+}
+
+// CloudVarImportItem 单个变量的导入结果
+type CloudVarImportItem struct {
+	Key      string          `json:"key"`
+	Template string          `json:"template,omitempty"`
+	Action   string          `json:"action"`           // create/update/unchanged/skip/conflict
+	Fields   []string        `json:"fields,omitempty"` // 发生变化的字段
+	Lines    []textdiff.Line `json:"lines,omitempty"`  // 值的逐行差异
+}
+
+type CloudVarImportResult struct {
+	DryRun    bool                 `json:"dry_run"`
+	Created   int                  `json:"created"`
+	Updated   int                  `json:"updated"`
+	Unchanged int                  `json:"unchanged"`
+	Skipped   int                  `json:"skipped"`
+	Conflicts int                  `json:"conflicts"`
+	Items     []CloudVarImportItem `json:"items"`
+}
+
+// projectTemplateNames 返回项目模板的 ID->名称 和 名称->ID 映射
+func projectTemplateNames(projectID uint) (map[uint]string, map[string]uint, error) {
+	var templates []models.CardTemplate
+	if err := database.DB.Where("project_id = ?", projectID).Find(&templates).Error; err != nil {
+		return nil, nil, err
+	}
+	names := make(map[uint]string, len(templates))
+	ids := make(map[string]uint, len(templates))
+	for _, t := range templates {
+		names[t.ID] = t.Name
+		ids[t.Name] = t.ID
+	}
+	return names, ids, nil
+}
+
+// Export 导出项目的项目级和模板级变量
+func (s *CloudVarService) Export(projectID uint) (*CloudVarExport, error) {
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		return nil, errors.New("项目不存在")
+	}
+	names, _, err := projectTemplateNames(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var cloudVars []models.CloudVar
+	if err := database.DB.Where("project_id = ? AND card_id IS NULL", projectID).
+		Order("template_id IS NOT NULL, template_id, key").Find(&cloudVars).Error; err != nil {
+		return nil, err
+	}
+
+	// templateNames 换成模板名称，已删除的模板丢弃
+	templateNames := func(ids []uint) []string {
+		var out []string
+		for _, id := range ids {
+			if name, ok := names[id]; ok {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+
+	export := &CloudVarExport{
+		Version:    cloudVarExportVersion,
+		Project:    project.Name,
+		ExportedAt: time.Now(),
+		Vars:       make([]CloudVarExportItem, 0, len(cloudVars)),
+		Skipped:    []string{},
+	}
+	for _, v := range cloudVars {
+		item := CloudVarExportItem{
+			Key:            v.Key,
+			Type:           v.Type,
+			Value:          v.Value,
+			Schema:         v.Schema,
+			Visibility:     v.Visibility,
+			AllowTemplates: templateNames(v.AllowTemplates),
+			AllowFeatures:  v.AllowFeatures,
+		}
+		if v.TemplateID != nil {
+			name, ok := names[*v.TemplateID]
+			if !ok {
+				export.Skipped = append(export.Skipped, v.Key)
+				continue
+			}
+			item.Template = name
+		}
+		if len(v.AllowTemplates) > 0 && len(item.AllowTemplates) == 0 {
+			export.Skipped = append(export.Skipped, v.Key)
+			continue
+		}
+		for _, rule := range v.Rules {
+			templates := templateNames(rule.Templates)
+			// 限定的模板都已删除时丢弃该规则，避免变成不限模板
+			if len(rule.Templates) > 0 && len(templates) == 0 {
+				continue
+			}
+			item.Rules = append(item.Rules, CloudVarExportRule{
+				Name:       rule.Name,
+				Value:      rule.Value,
+				Percent:    rule.Percent,
+				Templates:  templates,
+				HWIDs:      rule.HWIDs,
+				MinVersion: rule.MinVersion,
+				MaxVersion: rule.MaxVersion,
+			})
+		}
+		export.Vars = append(export.Vars, item)
+	}
+	return export, nil
+}
+
+// Marshal 按格式序列化导出内容，format 为 json 或 yaml
+func (e *CloudVarExport) Marshal(format string) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.MarshalIndent(e, "", "  ")
+	case "yaml", "yml":
+		return yaml.Marshal(e)
+	}
+	return nil, errors.New("格式须为 json 或 yaml")
+}
+
+// importRequest 把导出项转换为目标项目的写入请求，模板按名称查找
+func importRequest(projectID uint, item *CloudVarExportItem, templateIDs map[string]uint) (*CreateCloudVarRequest, error) {
+	lookup := func(name string) (uint, error) {
+		id, ok := templateIDs[name]
+		if !ok {
+			return 0, fmt.Errorf("目标项目没有模板 %s", name)
+		}
+		return id, nil
+	}
+
+	req := &CreateCloudVarRequest{
+		ProjectID:     projectID,
+		Key:           item.Key,
+		Value:         item.Value,
+		Type:          item.Type,
+		Schema:        item.Schema,
+		Visibility:    item.Visibility,
+		AllowFeatures: item.AllowFeatures,
+	}
+	if req.Type == "" {
+		req.Type = models.VarTypeString
+	}
+	if req.Visibility == "" {
+		req.Visibility = models.VisibilitySession
+	}
+	if item.Template != "" {
+		id, err := lookup(item.Template)
+		if err != nil {
+			return nil, err
+		}
+		req.TemplateID = &id
+	}
+	for _, name := range item.AllowTemplates {
+		id, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		req.AllowTemplates = append(req.AllowTemplates, id)
+	}
+
+	rules := models.RolloutRules{}
+	for _, r := range item.Rules {
+		rule := models.RolloutRule{
+			Name:       r.Name,
+			Value:      r.Value,
+			Percent:    r.Percent,
+			HWIDs:      r.HWIDs,
+			MinVersion: r.MinVersion,
+			MaxVersion: r.MaxVersion,
+		}
+		for _, name := range r.Templates {
+			id, err := lookup(name)
+			if err != nil {
+				return nil, fmt.Errorf("规则 %s: %v", r.Name, err)
+			}
+			rule.Templates = append(rule.Templates, id)
+		}
+		rules = append(rules, rule)
+	}
+	req.Rules = &rules
+	return req, nil
+}
+
+// changedFields 比较已有变量和导入请求，返回不同的字段
+func changedFields(current *models.CloudVar, req *CreateCloudVarRequest) []string {
+	var fields []string
+	if current.Value != req.Value {
+		fields = append(fields, "value")
+	}
+	if current.Type != req.Type {
+		fields = append(fields, "type")
+	}
+	if current.Schema != req.Schema {
+		fields = append(fields, "schema")
+	}
+	if current.Visibility != req.Visibility {
+		fields = append(fields, "visibility")
+	}
+	if req.Visibility == models.VisibilityEntitled || current.Visibility == models.VisibilityEntitled {
+		if !slices.Equal([]uint(current.AllowTemplates), req.AllowTemplates) {
+			fields = append(fields, "allow_templates")
+		}
+		if !slices.Equal([]string(current.AllowFeatures), req.AllowFeatures) {
+			fields = append(fields, "allow_features")
+		}
+	}
+	currentRules, _ := json.Marshal(current.Rules)
+	newRules, _ := json.Marshal(req.Rules)
+	if len(current.Rules) == 0 {
+		currentRules = []byte("[]")
+	}
+	if string(currentRules) != string(newRules) {
+		fields = append(fields, "rules")
+	}
+	return fields
+}
+
+// Import 把导出文件导入项目，dry_run 时只返回每个变量的处理方式和差异
+func (s *CloudVarService) Import(req *ImportCloudVarsRequest, adminID uint) (*CloudVarImportResult, error) {
+	if req.Conflict == "" {
+		req.Conflict = ImportConflictFail
+	}
+	if req.Conflict != ImportConflictOverwrite && req.Conflict != ImportConflictSkip && req.Conflict != ImportConflictFail {
+		return nil, errors.New("冲突处理方式须为 overwrite/skip/fail")
+	}
+	if _, err := NewProjectService().GetByID(req.ProjectID); err != nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	// YAML 兼容 JSON，两种格式都按 YAML 解析
+	var file CloudVarExport
+	if err := yaml.Unmarshal([]byte(req.Content), &file); err != nil {
+		return nil, fmt.Errorf("无法解析导入内容: %v", err)
+	}
+	if file.Version > cloudVarExportVersion {
+		return nil, fmt.Errorf("不支持的导出文件版本 %d", file.Version)
+	}
+	if len(file.Vars) == 0 {
+		return nil, errors.New("导入内容中没有变量")
+	}
+
+	_, templateIDs, err := projectTemplateNames(req.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先转换并校验全部变量，有错误时整批不导入
+	batchErr := &CloudVarBatchError{}
+	reqs := make([]*CreateCloudVarRequest, len(file.Vars))
+	seen := make(map[string]bool, len(file.Vars))
+	for i := range file.Vars {
+		item := &file.Vars[i]
+		id := item.Template + "\x00" + item.Key
+		if seen[id] {
+			batchErr.Errors = append(batchErr.Errors, CloudVarKeyError{Index: i, Key: item.Key, Error: "变量重复"})
+			continue
+		}
+		seen[id] = true
+		r, err := importRequest(req.ProjectID, item, templateIDs)
+		if err == nil {
+			err = checkCloudVar(r)
+		}
+		if err != nil {
+			batchErr.Errors = append(batchErr.Errors, CloudVarKeyError{Index: i, Key: item.Key, Error: err.Error()})
+			continue
+		}
+		reqs[i] = r
+	}
+	if len(batchErr.Errors) > 0 {
+		return nil, batchErr
+	}
+
+	result := &CloudVarImportResult{DryRun: req.DryRun, Items: make([]CloudVarImportItem, 0, len(reqs))}
+	var writes []*CreateCloudVarRequest
+	for i, r := range reqs {
+		item := CloudVarImportItem{Key: r.Key, Template: file.Vars[i].Template}
+
+		var current models.CloudVar
+		err := scopedVars(database.DB, r.ProjectID, r.TemplateID, nil).Where("key = ?", r.Key).First(&current).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			item.Action = "create"
+			item.Lines = textdiff.Lines("", r.Value)
+			result.Created++
+			version := 0
+			r.IfVersion = &version
+			writes = append(writes, r)
+		case err != nil:
+			return nil, err
+		default:
+			item.Fields = changedFields(&current, r)
+			if len(item.Fields) == 0 {
+				item.Action = "unchanged"
+				result.Unchanged++
+				break
+			}
+			if current.Value != r.Value {
+				item.Lines = textdiff.Lines(current.Value, r.Value)
+			}
+			switch req.Conflict {
+			case ImportConflictOverwrite:
+				item.Action = "update"
+				result.Updated++
+				// 按预览时的版本写入，期间被修改则整批失败
+				version := current.Version
+				r.IfVersion = &version
+				writes = append(writes, r)
+			case ImportConflictSkip:
+				item.Action = "skip"
+				result.Skipped++
+			default:
+				item.Action = "conflict"
+				result.Conflicts++
+			}
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if req.DryRun {
+		return result, nil
+	}
+	if result.Conflicts > 0 {
+		return result, ErrCloudVarImportConflict
+	}
+	if len(writes) == 0 {
+		return result, nil
+	}
+
+	author := adminAuthor(adminID)
+	author.note = "导入"
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, r := range writes {
+		if _, err := upsertCloudVar(tx, r, author); err != nil {
+			tx.Rollback()
+			if errors.Is(err, ErrCloudVarConflict) {
+				return nil, fmt.Errorf("%s: %w", r.Key, err)
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
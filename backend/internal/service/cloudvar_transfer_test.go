@@ -0,0 +1,74 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+func TestExportSkipsDeletedTemplates(t *testing.T) {
+	project, reader := setupVarReader(t)
+	vipID := *reader.card.TemplateID
+	gold, err := NewCardTemplateService().Create(&CreateCardTemplateRequest{ProjectID: project.ID, Name: "gold", Duration: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "project", Value: "1"})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, TemplateID: &gold.ID, Key: "scoped", Value: "1"})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "entitled", Value: "1", Visibility: models.VisibilityEntitled, AllowTemplates: []uint{gold.ID}})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "mixed", Value: "1", Visibility: models.VisibilityEntitled, AllowTemplates: []uint{vipID, gold.ID}})
+	percent := 50
+	rules := models.RolloutRules{
+		{Name: "gold", Value: "2", Templates: []uint{gold.ID}},
+		{Name: "half", Value: "3", Percent: &percent, Templates: []uint{vipID, gold.ID}},
+	}
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "rollout", Value: "1", Rules: &rules})
+
+	if err := database.DB.Delete(&models.CardTemplate{}, gold.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := NewCloudVarService().Export(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"entitled", "scoped"}; !reflect.DeepEqual(export.Skipped, want) {
+		t.Fatalf("Skipped = %v, want %v", export.Skipped, want)
+	}
+	items := make(map[string]CloudVarExportItem)
+	for _, item := range export.Vars {
+		items[item.Key] = item
+	}
+	if len(items) != 3 {
+		t.Fatalf("exported %v, want project/mixed/rollout", export.Vars)
+	}
+	if got := items["mixed"].AllowTemplates; !reflect.DeepEqual(got, []string{"vip"}) {
+		t.Fatalf("mixed allow_templates = %v, want [vip]", got)
+	}
+	if got := items["rollout"].Rules; len(got) != 1 || got[0].Name != "half" || !reflect.DeepEqual(got[0].Templates, []string{"vip"}) {
+		t.Fatalf("rollout rules = %+v, want only half with [vip]", got)
+	}
+
+	// 导出内容可以导入只有 vip 模板的项目
+	target, err := NewProjectService().Create(&CreateProjectRequest{Name: "target", Mode: "paid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCardTemplateService().Create(&CreateCardTemplateRequest{ProjectID: target.ID, Name: "vip", Duration: 3600}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := export.Marshal("yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewCloudVarService().Import(&ImportCloudVarsRequest{ProjectID: target.ID, Content: string(content)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 3 {
+		t.Fatalf("Created = %d, want 3", result.Created)
+	}
+}
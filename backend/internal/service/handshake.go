@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/crypto"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/utils"
+)
+
+// 握手会话有效期(秒)
+var sessionTTL = 86400
+
+func SetSessionTTL(seconds int) {
+	if seconds > 0 {
+		sessionTTL = seconds
+	}
+}
+
+type HandshakeService struct{}
+
+func NewHandshakeService() *HandshakeService {
+	return &HandshakeService{}
+}
+
+type HandshakeRequest struct {
+	ProjectUUID     string `json:"project_uuid"`
+	KeyVersion      int    `json:"key_version"`       // 客户端内置公钥对应的密钥版本，为空时使用活动密钥
+	ClientPublicKey string `json:"client_public_key"` // 客户端临时X25519公钥，Base64
+}
+
+type HandshakeResponse struct {
+	SessionID       string    `json:"session_id"`
+	ExpireAt        time.Time `json:"expire_at"`
+	KeyVersion      int       `json:"key_version"`
+	ServerPublicKey string    `json:"server_public_key"`
+	// 用会话密钥加密的 session_id，客户端解密比对以确认服务端持有私钥
+	Confirm string `json:"confirm"`
+}
+
+func (s *HandshakeService) Handshake(req *HandshakeRequest) (*HandshakeResponse, error) {
+	var project models.Project
+	if err := database.DB.Where("uuid = ?", req.ProjectUUID).First(&project).Error; err != nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	version := req.KeyVersion
+	if version <= 0 {
+		version = project.KeyVersion
+	}
+
+	var key models.ProjectKey
+	if err := database.DB.Where("project_id = ? AND version = ?", project.ID, version).First(&key).Error; err != nil {
+		return nil, errors.New("密钥版本不存在")
+	}
+	if !key.Accepts(time.Now()) {
+		return nil, errors.New("密钥版本已停用")
+	}
+	if key.Scheme != crypto.SchemeX25519 {
+		return nil, errors.New("项目未使用密钥交换加密方案")
+	}
+
+	encryptor, err := crypto.GetProjectEncryptor(project.ID, key.Version, key.Scheme, key.Key)
+	if err != nil {
+		return nil, err
+	}
+	exchanger, ok := encryptor.(crypto.KeyExchanger)
+	if !ok {
+		return nil, errors.New("项目未使用密钥交换加密方案")
+	}
+
+	clientPublicKey, err := base64.StdEncoding.DecodeString(req.ClientPublicKey)
+	if err != nil {
+		return nil, errors.New("无效的客户端公钥")
+	}
+	sessionEncryptor, err := exchanger.NewSession(clientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.CryptoSession{
+		SessionID:       utils.RandomString(32, utils.CharsetTypeAlphanumeric),
+		ProjectID:       project.ID,
+		KeyVersion:      key.Version,
+		ClientPublicKey: req.ClientPublicKey,
+		ExpireAt:        time.Now().Add(time.Duration(sessionTTL) * time.Second),
+	}
+	if err := database.DB.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	confirm, err := sessionEncryptor.Encrypt(session.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HandshakeResponse{
+		SessionID:       session.SessionID,
+		ExpireAt:        session.ExpireAt,
+		KeyVersion:      key.Version,
+		ServerPublicKey: base64.StdEncoding.EncodeToString(exchanger.PublicKey()),
+		Confirm:         confirm,
+	}, nil
+}
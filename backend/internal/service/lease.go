@@ -0,0 +1,49 @@
+package service
+
+import (
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/license"
+)
+
+// 租约过期后的宽限时长(秒)
+var leaseGrace = 600
+
+func SetLeaseGrace(seconds int) {
+	if seconds > 0 {
+		leaseGrace = seconds
+	}
+}
+
+// issueLease 按Token有效期签发租约，宽限期不超过卡密到期时间
+func issueLease(project *models.Project, token *models.Token, card *models.Card) (string, error) {
+	signer, err := middleware.GetProjectSigner(project.ID)
+	if err != nil {
+		return "", err
+	}
+
+	expireAt := token.ExpireAt
+	graceUntil := expireAt.Add(time.Duration(leaseGrace) * time.Second)
+	lease := &license.Lease{
+		ProjectUUID: project.UUID,
+		HWID:        token.HWID,
+		IssuedAt:    time.Now().Unix(),
+	}
+	if card != nil {
+		lease.CardID = card.ID
+		if card.ExpireAt != nil {
+			if card.ExpireAt.Before(expireAt) {
+				expireAt = *card.ExpireAt
+			}
+			if card.ExpireAt.Before(graceUntil) {
+				graceUntil = *card.ExpireAt
+			}
+		}
+	}
+	lease.ExpireAt = expireAt.Unix()
+	lease.GraceUntil = graceUntil.Unix()
+
+	return license.SignLease(lease, signer.PrivateKey())
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/license"
+	"gorm.io/gorm"
+)
+
+type LicenseService struct{}
+
+func NewLicenseService() *LicenseService {
+	return &LicenseService{}
+}
+
+type IssueLicenseRequest struct {
+	HWID       string   `json:"hwid"`
+	ExpireAt   *int64   `json:"expire_at"` // Unix时间戳，为空时按卡密到期时间
+	Duration   int      `json:"duration"`  // 有效期(秒)，与 expire_at 二选一
+	Features   []string `json:"features"`
+	CustomData *string  `json:"custom_data"` // 为空时使用卡密的自定义数据
+	Note       string   `json:"note"`
+}
+
+// Issue 为卡密签发绑定设备码的离线授权
+func (s *LicenseService) Issue(cardID uint, req *IssueLicenseRequest) (*models.OfflineLicense, error) {
+	if req.HWID == "" {
+		return nil, errors.New("设备码不能为空")
+	}
+
+	var card models.Card
+	if err := database.DB.Preload("Project").First(&card, cardID).Error; err != nil {
+		return nil, errors.New("卡密不存在")
+	}
+	if card.Project == nil {
+		return nil, errors.New("项目不存在")
+	}
+	if card.IsFrozen() {
+		return nil, errors.New("卡密已冻结")
+	}
+	if card.IsExpired() {
+		return nil, errors.New("卡密已过期")
+	}
+
+	now := time.Now()
+	// 未激活的卡密按签发时激活，避免之后正常登录再次按完整时长计算
+	activated := activateCard(&card, now)
+
+	var expireAt *time.Time
+	switch {
+	case req.ExpireAt != nil:
+		t := time.Unix(*req.ExpireAt, 0)
+		expireAt = &t
+	case req.Duration > 0:
+		t := now.Add(time.Duration(req.Duration) * time.Second)
+		expireAt = &t
+	case card.ExpireAt != nil:
+		expireAt = card.ExpireAt
+	}
+	if expireAt != nil && !expireAt.After(now) {
+		return nil, errors.New("到期时间必须晚于当前时间")
+	}
+
+	customData := card.CustomData
+	if req.CustomData != nil {
+		customData = *req.CustomData
+	}
+
+	signer, err := middleware.GetProjectSigner(card.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &license.License{
+		ID:          uuid.New().String(),
+		ProjectUUID: card.Project.UUID,
+		CardID:      card.ID,
+		HWID:        req.HWID,
+		IssuedAt:    now.Unix(),
+		Features:    req.Features,
+		CustomData:  customData,
+	}
+	if expireAt != nil {
+		payload.ExpireAt = expireAt.Unix()
+	}
+
+	content, err := license.Sign(payload, signer.PrivateKey())
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.OfflineLicense{
+		LicenseID:  payload.ID,
+		ProjectID:  card.ProjectID,
+		CardID:     card.ID,
+		HWID:       req.HWID,
+		Features:   req.Features,
+		CustomData: customData,
+		ExpireAt:   expireAt,
+		Content:    content,
+		Note:       req.Note,
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if activated {
+		if err := saveCardActivation(tx, &card); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Create(record).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// activateCard 未激活的卡密从 now 开始计算到期时间，返回是否发生了激活
+func activateCard(card *models.Card, now time.Time) bool {
+	if card.Activated {
+		return false
+	}
+	card.Activated = true
+	card.ActivatedAt = &now
+	if card.Duration > 0 {
+		expireAt := now.Add(time.Duration(card.Duration) * time.Second)
+		card.ExpireAt = &expireAt
+	}
+	return true
+}
+
+func saveCardActivation(db *gorm.DB, card *models.Card) error {
+	return db.Model(card).Select("activated", "activated_at", "expire_at").Updates(card).Error
+}
+
+func (s *LicenseService) List(projectID, cardID uint, page, pageSize int) ([]models.OfflineLicense, int64, error) {
+	var licenses []models.OfflineLicense
+	var total int64
+
+	query := database.DB.Model(&models.OfflineLicense{})
+	if projectID > 0 {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if cardID > 0 {
+		query = query.Where("card_id = ?", cardID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+
+	if err := query.Order("id DESC").Find(&licenses).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return licenses, total, nil
+}
+
+func (s *LicenseService) GetByID(id uint) (*models.OfflineLicense, error) {
+	var record models.OfflineLicense
+	if err := database.DB.First(&record, id).Error; err != nil {
+		return nil, errors.New("离线授权不存在")
+	}
+	return &record, nil
+}
+
+// Revoke 吊销离线授权，已下发的授权文件仍可离线使用，但不能再兑换在线会话
+func (s *LicenseService) Revoke(id uint) error {
+	result := database.DB.Model(&models.OfflineLicense{}).Where("id = ?", id).Update("revoked", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("离线授权不存在")
+	}
+	return nil
+}
+
+type ExchangeLicenseRequest struct {
+	ProjectUUID   string `json:"project_uuid"`
+	License       string `json:"license"`
+	HWID          string `json:"hwid"`
+	ClientVersion string `json:"client_version"`
+}
+
+// Exchange 客户端恢复联网后用离线授权换取普通登录会话
+func (s *LicenseService) Exchange(projectID uint, req *ExchangeLicenseRequest) (*LoginResponse, error) {
+	signer, err := middleware.GetProjectSigner(projectID)
+	if err != nil {
+		return nil, errors.New("认证失败")
+	}
+
+	payload, err := license.Verify(req.License, signer.PublicKey())
+	if err != nil {
+		return nil, errors.New("认证失败")
+	}
+	if err := payload.Check(req.ProjectUUID, req.HWID, time.Now()); err != nil {
+		return nil, errors.New("认证失败")
+	}
+
+	var record models.OfflineLicense
+	if err := database.DB.Where("license_id = ? AND project_id = ?", payload.ID, projectID).First(&record).Error; err != nil {
+		return nil, errors.New("认证失败")
+	}
+	if record.Revoked {
+		return nil, errors.New("授权已吊销")
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		return nil, errors.New("认证失败")
+	}
+
+	var card models.Card
+	if err := database.DB.Where("id = ? AND project_id = ?", record.CardID, projectID).First(&card).Error; err != nil {
+		return nil, errors.New("认证失败")
+	}
+	// 兼容签发时未激活卡密的旧授权
+	if activateCard(&card, time.Now()) {
+		if err := saveCardActivation(database.DB, &card); err != nil {
+			return nil, err
+		}
+	}
+	if card.IsFrozen() || card.IsExpired() {
+		return nil, errors.New("认证失败")
+	}
+	if err := checkProjectStatus(&project, card.CardKey, req.HWID); err != nil {
+		return nil, err
+	}
+
+	cardID := card.ID
+	token, err := createToken(&project, &cardID, req.HWID, req.ClientVersion)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := issueLease(&project, token, &card)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(&record).Update("exchanged_at", &now).Error; err != nil {
+		return nil, err
+	}
+	announcements, err := clientAnnouncements(project.ID, &varReader{card: &card, hwid: req.HWID, clientVersion: req.ClientVersion}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:         token.Token,
+		ExpireAt:      token.ExpireAt,
+		Card:          &card,
+		Lease:         lease,
+		Entitlements:  resolveCardEntitlements(&card),
+		Announcements: announcements,
+	}, nil
+}
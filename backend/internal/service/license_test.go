@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+func TestIssueActivatesCard(t *testing.T) {
+	project, reader := setupVarReader(t)
+	cardID := reader.card.ID
+
+	record, err := NewLicenseService().Issue(cardID, &IssueLicenseRequest{HWID: "hw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var card models.Card
+	if err := database.DB.First(&card, cardID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !card.Activated || card.ActivatedAt == nil || card.ExpireAt == nil {
+		t.Fatalf("card not activated: %+v", card)
+	}
+	if record.ExpireAt == nil || !record.ExpireAt.Equal(*card.ExpireAt) {
+		t.Fatalf("license expire_at = %v, card expire_at = %v", record.ExpireAt, card.ExpireAt)
+	}
+
+	resp, err := NewLicenseService().Exchange(project.ID, &ExchangeLicenseRequest{ProjectUUID: project.UUID, License: record.Content, HWID: "hw"})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if !resp.Card.ExpireAt.Equal(*card.ExpireAt) {
+		t.Fatalf("exchange changed expire_at to %v", resp.Card.ExpireAt)
+	}
+	if resp.Announcements == nil {
+		t.Fatal("exchange response has no announcements list")
+	}
+}
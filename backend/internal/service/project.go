@@ -2,13 +2,18 @@ package service
 
 import (
 	"errors"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nextkey/nextkey/backend/internal/crypto"
 	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
 	"github.com/nextkey/nextkey/backend/internal/models"
+	"github.com/nextkey/nextkey/backend/pkg/jsonschema"
 	"github.com/nextkey/nextkey/backend/pkg/utils"
+	"gorm.io/gorm"
 )
 
 type ProjectService struct{}
@@ -31,6 +36,99 @@ type CreateProjectRequest struct {
 	UnbindDeductTime int    `json:"unbind_deduct_time"`
 	UnbindCooldown   int    `json:"unbind_cooldown"`
 	EncryptionScheme string `json:"encryption_scheme"` // 加密方案，默认 aes-256-gcm
+	// 为空时不修改，传空字符串表示取消校验
+	CustomDataSchema  *string `json:"custom_data_schema"`
+	CustomDataMaxSize *int    `json:"custom_data_max_size"`
+	CardVarMaxCount   *int    `json:"card_var_max_count"`
+	CardVarMaxSize    *int    `json:"card_var_max_size"`
+}
+
+// ProjectStatusError 项目维护或停用，客户端收到状态和提示
+type ProjectStatusError struct {
+	Code int
+	Info models.ProjectStatusInfo
+}
+
+func (e *ProjectStatusError) Error() string {
+	return e.Info.Message
+}
+
+func checkProjectStatus(project *models.Project, cardKey, hwid string) error {
+	if project.Allows(cardKey, hwid) {
+		return nil
+	}
+	return &ProjectStatusError{Code: project.StatusCode(), Info: project.StatusInfo()}
+}
+
+type UpdateProjectStatusRequest struct {
+	Status    string   `json:"status"`
+	Message   string   `json:"message"`
+	Whitelist []string `json:"whitelist"` // 维护期间放行的卡密或设备码，不传时保持原白名单
+}
+
+// UpdateStatus 切换项目状态，立即对本实例生效，已登录的会话在下次心跳或读取云变量时收到提示
+func (s *ProjectService) UpdateStatus(id uint, req *UpdateProjectStatusRequest) (*models.Project, error) {
+	switch req.Status {
+	case models.ProjectStatusActive, models.ProjectStatusMaintenance, models.ProjectStatusSuspended:
+	default:
+		return nil, errors.New("不支持的项目状态: " + req.Status)
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, id).Error; err != nil {
+		return nil, errors.New("项目不存在")
+	}
+
+	if req.Whitelist != nil {
+		whitelist := make(models.StringArray, 0, len(req.Whitelist))
+		for _, entry := range req.Whitelist {
+			entry = strings.TrimSpace(entry)
+			if entry != "" && !slices.Contains(whitelist, entry) {
+				whitelist = append(whitelist, entry)
+			}
+		}
+		project.StatusWhitelist = whitelist
+	}
+
+	project.Status = req.Status
+	project.StatusMessage = strings.TrimSpace(req.Message)
+	if err := database.DB.Model(&project).Select("status", "status_message", "status_whitelist").Updates(&project).Error; err != nil {
+		return nil, err
+	}
+	middleware.InvalidateProjectCache(project.ID)
+
+	return &project, nil
+}
+
+// applyClientDataRules 校验并设置客户端可写数据(专属信息、卡密云变量)的规则
+func applyClientDataRules(project *models.Project, req *CreateProjectRequest) error {
+	if req.CustomDataSchema != nil {
+		if *req.CustomDataSchema != "" {
+			if _, err := jsonschema.Compile(*req.CustomDataSchema); err != nil {
+				return err
+			}
+		}
+		project.DataSchema = *req.CustomDataSchema
+	}
+	if req.CustomDataMaxSize != nil {
+		if *req.CustomDataMaxSize <= 0 {
+			return errors.New("专属信息大小上限必须大于0")
+		}
+		project.DataMaxSize = *req.CustomDataMaxSize
+	}
+	if req.CardVarMaxCount != nil {
+		if *req.CardVarMaxCount < 0 {
+			return errors.New("卡密云变量个数上限不能为负数")
+		}
+		project.CardVarMaxCount = *req.CardVarMaxCount
+	}
+	if req.CardVarMaxSize != nil {
+		if *req.CardVarMaxSize <= 0 {
+			return errors.New("卡密云变量大小上限必须大于0")
+		}
+		project.CardVarMaxSize = *req.CardVarMaxSize
+	}
+	return nil
 }
 
 func (s *ProjectService) generateUnbindSlug() (string, error) {
@@ -47,7 +145,14 @@ func (s *ProjectService) generateUnbindSlug() (string, error) {
 	return "", errors.New("生成解绑链接失败")
 }
 
+// fillPublicKeys 计算返回给管理端的公钥字段
+func fillPublicKeys(project *models.Project) {
+	project.PublicKey = crypto.PublicKeyOf(project.EncryptionScheme, project.EncryptionKey)
+	project.SigningPublicKey = crypto.SigningPublicKeyOf(project.SigningKey)
+}
+
 func (s *ProjectService) ensureUnbindSlug(project *models.Project) error {
+	fillPublicKeys(project)
 	if project.UnbindSlug != "" {
 		return nil
 	}
@@ -98,15 +203,50 @@ func (s *ProjectService) Create(req *CreateProjectRequest) (*models.Project, err
 		UnbindCooldown:   req.UnbindCooldown,
 		EncryptionScheme: req.EncryptionScheme,
 		EncryptionKey:    encryptionKey,
+		KeyVersion:       1,
+		SigningKey:       crypto.GenerateSigningKey(),
+	}
+	if err := applyClientDataRules(project, req); err != nil {
+		return nil, err
 	}
 
-	if err := database.DB.Create(project).Error; err != nil {
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Create(project).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Create(initialProjectKey(project)).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
 
+	fillPublicKeys(project)
 	return project, nil
 }
 
+// initialProjectKey 新建项目时登记首个活动密钥
+func initialProjectKey(project *models.Project) *models.ProjectKey {
+	now := time.Now()
+	return &models.ProjectKey{
+		ProjectID:   project.ID,
+		Version:     project.KeyVersion,
+		Scheme:      project.EncryptionScheme,
+		Key:         project.EncryptionKey,
+		Status:      models.KeyStatusActive,
+		ActivatedAt: &now,
+	}
+}
+
 func (s *ProjectService) List(page, pageSize int) ([]models.Project, int64, error) {
 	var projects []models.Project
 	var total int64
@@ -174,16 +314,93 @@ func (s *ProjectService) Update(id uint, req *CreateProjectRequest) (*models.Pro
 	project.UnbindVerifyHWID = req.UnbindVerifyHWID
 	project.UnbindDeductTime = req.UnbindDeductTime
 	project.UnbindCooldown = req.UnbindCooldown
+	if err := applyClientDataRules(&project, req); err != nil {
+		return nil, err
+	}
 
 	if err := database.DB.Save(&project).Error; err != nil {
 		return nil, err
 	}
+	middleware.InvalidateProjectCache(project.ID)
 
 	return &project, nil
 }
 
 func (s *ProjectService) Delete(id uint) error {
-	return database.DB.Delete(&models.Project{}, id).Error
+	return s.BatchDelete([]uint{id})
+}
+
+// ProjectDeleteImpact 删除项目时受影响的记录数
+type ProjectDeleteImpact struct {
+	ProjectID     uint   `json:"project_id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Cards         int64  `json:"cards"`
+	ActiveCards   int64  `json:"active_cards"` // 已激活的卡密
+	Tokens        int64  `json:"tokens"`       // 将被注销的未过期会话
+	Templates     int64  `json:"templates"`
+	CloudVars     int64  `json:"cloud_vars"`
+	UnbindRecords int64  `json:"unbind_records"`
+	Announcements int64  `json:"announcements"`
+}
+
+type ProjectDeletePreview struct {
+	Projects []ProjectDeleteImpact `json:"projects"`
+	Summary  ProjectDeleteImpact   `json:"summary"`
+}
+
+// DeletePreview 统计删除项目将注销的会话和一并移入回收站的下级记录，不做修改
+func (s *ProjectService) DeletePreview(ids []uint) (*ProjectDeletePreview, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("未选择项目")
+	}
+
+	var projects []models.Project
+	if err := database.DB.Where("id IN ?", ids).Order("id ASC").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	if len(projects) == 0 {
+		return nil, errors.New("项目不存在")
+	}
+
+	preview := &ProjectDeletePreview{Projects: make([]ProjectDeleteImpact, 0, len(projects))}
+	now := time.Now()
+	for _, project := range projects {
+		impact := ProjectDeleteImpact{ProjectID: project.ID, Name: project.Name}
+		cards := database.DB.Model(&models.Card{}).Where("project_id = ?", project.ID)
+		if err := cards.Count(&impact.Cards).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.Card{}).Where("project_id = ? AND activated = ?", project.ID, true).Count(&impact.ActiveCards).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.Token{}).Where("project_id = ? AND expire_at > ?", project.ID, now).Count(&impact.Tokens).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.CardTemplate{}).Where("project_id = ?", project.ID).Count(&impact.Templates).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.CloudVar{}).Where("project_id = ?", project.ID).Count(&impact.CloudVars).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.UnbindRecord{}).
+			Where("card_id IN (?)", database.DB.Model(&models.Card{}).Select("id").Where("project_id = ?", project.ID)).
+			Count(&impact.UnbindRecords).Error; err != nil {
+			return nil, err
+		}
+		if err := database.DB.Model(&models.Announcement{}).Where("project_id = ?", project.ID).Count(&impact.Announcements).Error; err != nil {
+			return nil, err
+		}
+
+		preview.Projects = append(preview.Projects, impact)
+		preview.Summary.Cards += impact.Cards
+		preview.Summary.ActiveCards += impact.ActiveCards
+		preview.Summary.Tokens += impact.Tokens
+		preview.Summary.Templates += impact.Templates
+		preview.Summary.CloudVars += impact.CloudVars
+		preview.Summary.UnbindRecords += impact.UnbindRecords
+		preview.Summary.Announcements += impact.Announcements
+	}
+	return preview, nil
 }
 
 func (s *ProjectService) BatchCreate(reqs []CreateProjectRequest) ([]*models.Project, error) {
@@ -242,13 +459,24 @@ func (s *ProjectService) BatchCreate(reqs []CreateProjectRequest) ([]*models.Pro
 			UnbindCooldown:   req.UnbindCooldown,
 			EncryptionScheme: req.EncryptionScheme,
 			EncryptionKey:    encryptionKey,
+			KeyVersion:       1,
+			SigningKey:       crypto.GenerateSigningKey(),
+		}
+		if err := applyClientDataRules(project, &req); err != nil {
+			tx.Rollback()
+			return nil, err
 		}
 
 		if err := tx.Create(project).Error; err != nil {
 			tx.Rollback()
 			return nil, err
 		}
+		if err := tx.Create(initialProjectKey(project)).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 
+		fillPublicKeys(project)
 		projects = append(projects, project)
 	}
 
@@ -259,11 +487,17 @@ func (s *ProjectService) BatchCreate(reqs []CreateProjectRequest) ([]*models.Pro
 	return projects, nil
 }
 
+// BatchDelete 删除项目并注销其会话，卡密、模板、云变量、解绑记录和公告一并移入回收站
 func (s *ProjectService) BatchDelete(ids []uint) error {
 	if len(ids) == 0 {
 		return errors.New("未选择项目")
 	}
 
+	var cloudVars []models.CloudVar
+	if err := database.DB.Where("project_id IN ?", ids).Find(&cloudVars).Error; err != nil {
+		return err
+	}
+
 	tx := database.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -271,41 +505,302 @@ func (s *ProjectService) BatchDelete(ids []uint) error {
 		}
 	}()
 
+	// 先删除项目再删除下级记录，回收站按删除时间不早于项目判断哪些记录随项目恢复
 	if err := tx.Where("id IN ?", ids).Delete(&models.Project{}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
+	// 注销会话，恢复项目时不恢复
+	if err := tx.Where("project_id IN ?", ids).Delete(&models.Token{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := deleteCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("card_id IN (?)", tx.Model(&models.Card{}).Select("id").Where("project_id IN ?", ids)).
+		Delete(&models.UnbindRecord{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("project_id IN ?", ids).Delete(&models.Card{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("project_id IN ?", ids).Delete(&models.CardTemplate{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("project_id IN ?", ids).Delete(&models.Announcement{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		middleware.InvalidateProjectCache(id)
+	}
+	return nil
 }
 
-// UpdateEncryptionScheme 更新项目的加密方案
-func (s *ProjectService) UpdateEncryptionScheme(id uint, scheme string) (*models.Project, error) {
-	var project models.Project
-	if err := database.DB.First(&project, id).Error; err != nil {
+type CloneProjectRequest struct {
+	Name         string `json:"name"`          // 为空时使用 "原名称 - 副本"
+	IncludeCards bool   `json:"include_cards"` // 复制卡密配置并生成新卡密号，不复制激活状态、绑定和专属信息
+	CardPrefix   string `json:"card_prefix"`   // 复制卡密时新卡密号的前缀
+}
+
+type CloneProjectResult struct {
+	Project   *models.Project `json:"project"`
+	Templates int             `json:"templates"`
+	CloudVars int             `json:"cloud_vars"`
+	Cards     int             `json:"cards"`
+	// 引用的模板已全部删除而未复制的云变量名，复制后会变成不限模板或失去所属模板
+	SkippedVars []string `json:"skipped_vars"`
+}
+
+// Clone 把项目设置、卡密模板和项目级/模板级云变量复制到新项目，UUID、解绑链接和密钥重新生成
+func (s *ProjectService) Clone(id uint, req *CloneProjectRequest, adminID uint) (*CloneProjectResult, error) {
+	var source models.Project
+	if err := database.DB.First(&source, id).Error; err != nil {
 		return nil, errors.New("项目不存在")
 	}
 
-	// 验证加密方案是否支持
-	if !crypto.SchemeExists(scheme) {
-		return nil, errors.New("不支持的加密方案: " + scheme)
+	name := req.Name
+	if name == "" {
+		name = source.Name + " - 副本"
 	}
-
-	// 生成新密钥
-	encryptionKey, err := crypto.GenerateKey(scheme)
+	encryptionKey, err := crypto.GenerateKey(source.EncryptionScheme)
+	if err != nil {
+		return nil, err
+	}
+	unbindSlug, err := s.generateUnbindSlug()
 	if err != nil {
 		return nil, err
 	}
 
-	// 更新项目
-	project.EncryptionScheme = scheme
-	project.EncryptionKey = encryptionKey
+	project := &models.Project{
+		UUID:             uuid.New().String(),
+		UnbindSlug:       unbindSlug,
+		Name:             name,
+		Mode:             source.Mode,
+		EnableHWID:       source.EnableHWID,
+		EnableIP:         source.EnableIP,
+		Version:          source.Version,
+		UpdateURL:        source.UpdateURL,
+		TokenExpire:      source.TokenExpire,
+		Description:      source.Description,
+		EnableUnbind:     source.EnableUnbind,
+		UnbindVerifyHWID: source.UnbindVerifyHWID,
+		UnbindDeductTime: source.UnbindDeductTime,
+		UnbindCooldown:   source.UnbindCooldown,
+		EncryptionScheme: source.EncryptionScheme,
+		EncryptionKey:    encryptionKey,
+		KeyVersion:       1,
+		SigningKey:       crypto.GenerateSigningKey(),
+		DataSchema:       source.DataSchema,
+		DataMaxSize:      source.DataMaxSize,
+		CardVarMaxCount:  source.CardVarMaxCount,
+		CardVarMaxSize:   source.CardVarMaxSize,
+	}
 
-	if err := database.DB.Save(&project).Error; err != nil {
+	// 事务开始前读取源数据，SQLite 只有一个连接
+	var templates []models.CardTemplate
+	if err := database.DB.Where("project_id = ?", id).Order("id ASC").Find(&templates).Error; err != nil {
 		return nil, err
 	}
+	var cloudVars []models.CloudVar
+	if err := database.DB.Where("project_id = ? AND card_id IS NULL", id).Order("id ASC").Find(&cloudVars).Error; err != nil {
+		return nil, err
+	}
+	var cards []models.Card
+	if req.IncludeCards {
+		if err := database.DB.Where("project_id = ?", id).Order("id ASC").Find(&cards).Error; err != nil {
+			return nil, err
+		}
+	}
+	author := adminAuthor(adminID)
+	author.note = "从项目 " + source.Name + " 复制"
 
-	return &project, nil
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Create(project).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Create(initialProjectKey(project)).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	templateIDs := make(map[uint]uint, len(templates))
+	for _, t := range templates {
+		clone := models.CardTemplate{
+			ProjectID: project.ID,
+			Name:      t.Name,
+			Duration:  t.Duration,
+			CardType:  t.CardType,
+			MaxHWID:   t.MaxHWID,
+			MaxIP:     t.MaxIP,
+			Features:  t.Features,
+			Quotas:    t.Quotas,
+			Meters:    t.Meters,
+			Note:      t.Note,
+		}
+		if err := tx.Create(&clone).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		templateIDs[t.ID] = clone.ID
+	}
+	// mapTemplates 换成新项目的模板ID，已删除的模板丢弃
+	mapTemplates := func(ids []uint) []uint {
+		mapped := []uint{}
+		for _, id := range ids {
+			if newID, ok := templateIDs[id]; ok {
+				mapped = append(mapped, newID)
+			}
+		}
+		return mapped
+	}
+
+	copiedVars := 0
+	skippedVars := []string{}
+	for _, v := range cloudVars {
+		var templateID *uint
+		if v.TemplateID != nil {
+			newID, ok := templateIDs[*v.TemplateID]
+			if !ok {
+				skippedVars = append(skippedVars, v.Key)
+				continue
+			}
+			templateID = &newID
+		}
+		allowTemplates := mapTemplates(v.AllowTemplates)
+		if len(v.AllowTemplates) > 0 && len(allowTemplates) == 0 {
+			skippedVars = append(skippedVars, v.Key)
+			continue
+		}
+		rules := make(models.RolloutRules, 0, len(v.Rules))
+		for _, rule := range v.Rules {
+			mapped := mapTemplates(rule.Templates)
+			// 限定的模板都已删除时丢弃该规则，避免变成不限模板
+			if len(rule.Templates) > 0 && len(mapped) == 0 {
+				continue
+			}
+			rule.Templates = mapped
+			rules = append(rules, rule)
+		}
+		if _, err := upsertCloudVar(tx, &CreateCloudVarRequest{
+			ProjectID:      project.ID,
+			TemplateID:     templateID,
+			Key:            v.Key,
+			Value:          v.Value,
+			Type:           v.Type,
+			Schema:         v.Schema,
+			Visibility:     v.Visibility,
+			AllowTemplates: allowTemplates,
+			AllowFeatures:  v.AllowFeatures,
+			Rules:          &rules,
+		}, author); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		copiedVars++
+	}
+
+	newCards := make([]models.Card, 0, len(cards))
+	usedKeys := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		cardKey, err := generateCloneCardKey(tx, req.CardPrefix, usedKeys)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		clone := models.Card{
+			CardKey:   cardKey,
+			ProjectID: project.ID,
+			Frozen:    card.Frozen,
+			Duration:  card.Duration,
+			Note:      card.Note,
+			CardType:  card.CardType,
+			AdminData: card.AdminData,
+			HWIDList:  make(models.StringArray, 0),
+			IPList:    make(models.StringArray, 0),
+			MaxHWID:   card.MaxHWID,
+			MaxIP:     card.MaxIP,
+			Features:  card.Features,
+			Quotas:    card.Quotas,
+			Meters:    card.Meters,
+		}
+		if card.TemplateID != nil {
+			if newID, ok := templateIDs[*card.TemplateID]; ok {
+				clone.TemplateID = &newID
+			}
+		}
+		newCards = append(newCards, clone)
+	}
+	if len(newCards) > 0 {
+		if err := tx.CreateInBatches(&newCards, 100).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	fillPublicKeys(project)
+	return &CloneProjectResult{
+		Project:     project,
+		Templates:   len(templates),
+		CloudVars:   copiedVars,
+		Cards:       len(newCards),
+		SkippedVars: skippedVars,
+	}, nil
+}
+
+// generateCloneCardKey 生成与已有卡密(含回收站中的)和本次已生成的卡密都不重复的卡密号
+func generateCloneCardKey(tx *gorm.DB, prefix string, used map[string]bool) (string, error) {
+	for i := 0; i < 5; i++ {
+		cardKey := utils.GenerateCardKey(prefix, "", 16, utils.CharsetTypeAlphanumeric)
+		if used[cardKey] {
+			continue
+		}
+		var count int64
+		if err := tx.Unscoped().Model(&models.Card{}).Where("card_key = ?", cardKey).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			used[cardKey] = true
+			return cardKey, nil
+		}
+	}
+	return "", errors.New("生成卡密失败")
+}
+
+// UpdateEncryptionScheme 更换项目的加密方案
+// 生成新版本密钥并立即激活，旧密钥按默认宽限期继续可用
+func (s *ProjectService) UpdateEncryptionScheme(id uint, scheme string) (*models.Project, error) {
+	// 验证加密方案是否支持
+	if !crypto.SchemeExists(scheme) {
+		return nil, errors.New("不支持的加密方案: " + scheme)
+	}
+
+	key, err := s.StageKey(id, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.ActivateKey(id, key.Version, nil)
 }
 
 func (s *ProjectService) GetByUnbindSlug(slug string) (*models.Project, error) {
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/crypto"
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+// 旧密钥在新密钥激活后的默认宽限期(秒)
+var keyGracePeriod = 7 * 24 * 3600
+
+func SetKeyGracePeriod(seconds int) {
+	if seconds >= 0 {
+		keyGracePeriod = seconds
+	}
+}
+
+type ProjectKeyResponse struct {
+	models.ProjectKey
+	EffectiveStatus string `json:"effective_status"`
+	PublicKey       string `json:"public_key,omitempty"` // 密钥交换方案的服务端公钥，写入客户端配置
+}
+
+func (s *ProjectService) ListKeys(projectID uint) ([]ProjectKeyResponse, error) {
+	if _, err := s.GetByID(projectID); err != nil {
+		return nil, err
+	}
+
+	var keys []models.ProjectKey
+	if err := database.DB.Where("project_id = ?", projectID).Order("version DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	responses := make([]ProjectKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = ProjectKeyResponse{
+			ProjectKey:      keys[i],
+			EffectiveStatus: keys[i].EffectiveStatus(now),
+			PublicKey:       crypto.PublicKeyOf(keys[i].Scheme, keys[i].Key),
+		}
+	}
+	return responses, nil
+}
+
+// StageKey 生成新版本密钥，激活前仅接受明确指定该版本的请求
+func (s *ProjectService) StageKey(projectID uint, scheme string) (*ProjectKeyResponse, error) {
+	project, err := s.GetByID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheme == "" {
+		scheme = project.EncryptionScheme
+	}
+	if !crypto.SchemeExists(scheme) {
+		return nil, errors.New("不支持的加密方案: " + scheme)
+	}
+
+	encryptionKey, err := crypto.GenerateKey(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var maxVersion int
+	if err := tx.Model(&models.ProjectKey{}).Where("project_id = ?", projectID).
+		Select("COALESCE(MAX(version), 0)").Scan(&maxVersion).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if maxVersion < project.KeyVersion {
+		maxVersion = project.KeyVersion
+	}
+
+	key := models.ProjectKey{
+		ProjectID: projectID,
+		Version:   maxVersion + 1,
+		Scheme:    scheme,
+		Key:       encryptionKey,
+		Status:    models.KeyStatusStaged,
+	}
+	if err := tx.Create(&key).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	middleware.InvalidateProjectCache(projectID)
+	return &ProjectKeyResponse{
+		ProjectKey:      key,
+		EffectiveStatus: key.Status,
+		PublicKey:       crypto.PublicKeyOf(key.Scheme, key.Key),
+	}, nil
+}
+
+// ActivateKey 将指定版本设为主密钥，原主密钥在宽限期内继续可用
+// gracePeriod 为空时使用配置的默认宽限期，为0时原主密钥立即停用
+func (s *ProjectService) ActivateKey(projectID uint, version int, gracePeriod *int) (*models.Project, error) {
+	grace := keyGracePeriod
+	if gracePeriod != nil {
+		if *gracePeriod < 0 {
+			return nil, errors.New("宽限期不能为负数")
+		}
+		grace = *gracePeriod
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var project models.Project
+	if err := tx.First(&project, projectID).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("项目不存在")
+	}
+
+	var key models.ProjectKey
+	if err := tx.Where("project_id = ? AND version = ?", projectID, version).First(&key).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("密钥版本不存在")
+	}
+	if key.Status == models.KeyStatusActive {
+		tx.Rollback()
+		return nil, errors.New("该密钥已是活动密钥")
+	}
+	if key.Status == models.KeyStatusRetired {
+		tx.Rollback()
+		return nil, errors.New("已停用的密钥不能重新激活")
+	}
+
+	now := time.Now()
+	retireAt := now.Add(time.Duration(grace) * time.Second)
+	status := models.KeyStatusRetiring
+	if grace == 0 {
+		status = models.KeyStatusRetired
+	}
+	if err := tx.Model(&models.ProjectKey{}).
+		Where("project_id = ? AND status = ?", projectID, models.KeyStatusActive).
+		Updates(map[string]interface{}{"status": status, "retire_at": retireAt}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	key.Status = models.KeyStatusActive
+	key.ActivatedAt = &now
+	key.RetireAt = nil
+	if err := tx.Save(&key).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// 项目上的加密字段始终与活动密钥保持一致
+	project.EncryptionScheme = key.Scheme
+	project.EncryptionKey = key.Key
+	project.KeyVersion = key.Version
+	if err := tx.Save(&project).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	middleware.InvalidateProjectCache(projectID)
+	fillPublicKeys(&project)
+	return &project, nil
+}
+
+// RetireKey 立即停用指定版本的非活动密钥
+func (s *ProjectService) RetireKey(projectID uint, version int) error {
+	var key models.ProjectKey
+	if err := database.DB.Where("project_id = ? AND version = ?", projectID, version).First(&key).Error; err != nil {
+		return errors.New("密钥版本不存在")
+	}
+	if key.Status == models.KeyStatusActive {
+		return errors.New("不能停用活动密钥，请先激活其他密钥")
+	}
+
+	now := time.Now()
+	key.Status = models.KeyStatusRetired
+	key.RetireAt = &now
+	if err := database.DB.Save(&key).Error; err != nil {
+		return err
+	}
+
+	middleware.InvalidateProjectCache(projectID)
+	return nil
+}
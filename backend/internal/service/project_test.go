@@ -0,0 +1,38 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+)
+
+func TestCloneReportsSkippedVars(t *testing.T) {
+	project, _ := setupVarReader(t)
+
+	template, err := NewCardTemplateService().Create(&CreateCardTemplateRequest{ProjectID: project.ID, Name: "gone", Duration: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "kept", Value: "1"})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, TemplateID: &template.ID, Key: "scoped", Value: "1"})
+	setTestVar(t, &CreateCloudVarRequest{ProjectID: project.ID, Key: "entitled", Value: "1", Visibility: models.VisibilityEntitled, AllowTemplates: []uint{template.ID}})
+	if err := database.DB.Delete(&models.CardTemplate{}, template.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewProjectService().Clone(project.ID, &CloneProjectRequest{IncludeCards: true}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CloudVars != 1 {
+		t.Errorf("CloudVars = %d, want 1", result.CloudVars)
+	}
+	if want := []string{"scoped", "entitled"}; !reflect.DeepEqual(result.SkippedVars, want) {
+		t.Errorf("SkippedVars = %v, want %v", result.SkippedVars, want)
+	}
+	if result.Cards != 1 {
+		t.Errorf("Cards = %d, want 1", result.Cards)
+	}
+}
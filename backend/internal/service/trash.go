@@ -0,0 +1,543 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/middleware"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	TrashTypeProject  = "project"
+	TrashTypeCard     = "card"
+	TrashTypeCloudVar = "cloud_var"
+)
+
+// 回收站中的记录保留天数，超过后由后台任务彻底删除
+var trashRetentionDays = 30
+
+func SetTrashRetention(days int) {
+	if days > 0 {
+		trashRetentionDays = days
+	}
+}
+
+var (
+	ErrTrashNotFound    = errors.New("回收站中不存在该记录")
+	ErrTrashInvalidType = errors.New("不支持的回收站类型")
+)
+
+type TrashService struct{}
+
+func NewTrashService() *TrashService {
+	return &TrashService{}
+}
+
+// TrashItem 回收站中的一条记录，Dependents 为恢复时一并恢复的下级记录数
+type TrashItem struct {
+	Type        string    `json:"type"`
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	ProjectID   uint      `json:"project_id"`
+	ProjectName string    `json:"project_name"`
+	Scope       string    `json:"scope,omitempty"` // 云变量作用域
+	Dependents  int64     `json:"dependents"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	ExpireAt    time.Time `json:"expire_at"` // 到期后彻底删除
+}
+
+type ListTrashFilter struct {
+	Type      string
+	ProjectID uint
+	Keyword   string
+}
+
+type TrashRestoreResult struct {
+	Templates int64 `json:"templates"`
+	Cards     int64 `json:"cards"`
+	CloudVars int64 `json:"cloud_vars"`
+}
+
+func trashExpireAt(deletedAt time.Time) time.Time {
+	return deletedAt.AddDate(0, 0, trashRetentionDays)
+}
+
+// trashQuery 只返回顶层删除的记录，随上级一起删除的记录在恢复上级时一并恢复
+func trashQuery(filter *ListTrashFilter) (*gorm.DB, error) {
+	db := database.DB.Unscoped()
+	var query *gorm.DB
+	switch filter.Type {
+	case TrashTypeProject:
+		query = db.Model(&models.Project{}).Where("deleted_at IS NOT NULL")
+		if filter.Keyword != "" {
+			query = query.Where("name LIKE ? ESCAPE '\\'", "%"+escapeLikeString(filter.Keyword)+"%")
+		}
+	case TrashTypeCard:
+		query = db.Model(&models.Card{}).Where("deleted_at IS NOT NULL").
+			Where("project_id IN (?)", database.DB.Model(&models.Project{}).Select("id"))
+		if filter.Keyword != "" {
+			query = query.Where("card_key LIKE ? ESCAPE '\\'", "%"+escapeLikeString(filter.Keyword)+"%")
+		}
+	case TrashTypeCloudVar:
+		query = db.Model(&models.CloudVar{}).Where("deleted_at IS NOT NULL").
+			Where("project_id IN (?)", database.DB.Model(&models.Project{}).Select("id")).
+			Where("template_id IS NULL OR template_id IN (?)", database.DB.Model(&models.CardTemplate{}).Select("id")).
+			Where("card_id IS NULL OR card_id IN (?)", database.DB.Model(&models.Card{}).Select("id"))
+		if filter.Keyword != "" {
+			query = query.Where("key LIKE ? ESCAPE '\\'", "%"+escapeLikeString(filter.Keyword)+"%")
+		}
+	default:
+		return nil, ErrTrashInvalidType
+	}
+	if filter.ProjectID > 0 {
+		column := "project_id"
+		if filter.Type == TrashTypeProject {
+			column = "id"
+		}
+		query = query.Where(column+" = ?", filter.ProjectID)
+	}
+	return query, nil
+}
+
+func (s *TrashService) List(filter *ListTrashFilter, page, pageSize int) ([]TrashItem, int64, error) {
+	query, err := trashQuery(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && pageSize > 0 {
+		offset := (page - 1) * pageSize
+		query = query.Offset(offset).Limit(pageSize)
+	}
+	query = query.Order("deleted_at DESC")
+
+	items := []TrashItem{}
+	switch filter.Type {
+	case TrashTypeProject:
+		var projects []models.Project
+		if err := query.Find(&projects).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, p := range projects {
+			deletedAt := p.DeletedAt.Time
+			var dependents int64
+			for _, model := range []interface{}{&models.CardTemplate{}, &models.Card{}, &models.CloudVar{}, &models.Announcement{}} {
+				var count int64
+				database.DB.Unscoped().Model(model).Where("project_id = ? AND deleted_at >= ?", p.ID, deletedAt).Count(&count)
+				dependents += count
+			}
+			items = append(items, TrashItem{
+				Type:        TrashTypeProject,
+				ID:          p.ID,
+				Name:        p.Name,
+				ProjectID:   p.ID,
+				ProjectName: p.Name,
+				Dependents:  dependents,
+				DeletedAt:   deletedAt,
+				ExpireAt:    trashExpireAt(deletedAt),
+			})
+		}
+	case TrashTypeCard:
+		var cards []models.Card
+		if err := query.Find(&cards).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, card := range cards {
+			deletedAt := card.DeletedAt.Time
+			var dependents int64
+			database.DB.Unscoped().Model(&models.CloudVar{}).Where("card_id = ? AND deleted_at >= ?", card.ID, deletedAt).Count(&dependents)
+			items = append(items, TrashItem{
+				Type:       TrashTypeCard,
+				ID:         card.ID,
+				Name:       card.CardKey,
+				ProjectID:  card.ProjectID,
+				Dependents: dependents,
+				DeletedAt:  deletedAt,
+				ExpireAt:   trashExpireAt(deletedAt),
+			})
+		}
+	case TrashTypeCloudVar:
+		var cloudVars []models.CloudVar
+		if err := query.Find(&cloudVars).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, v := range cloudVars {
+			deletedAt := v.DeletedAt.Time
+			items = append(items, TrashItem{
+				Type:      TrashTypeCloudVar,
+				ID:        v.ID,
+				Name:      v.Key,
+				ProjectID: v.ProjectID,
+				Scope:     v.Scope(),
+				DeletedAt: deletedAt,
+				ExpireAt:  trashExpireAt(deletedAt),
+			})
+		}
+	}
+
+	if filter.Type != TrashTypeProject && len(items) > 0 {
+		projectIDs := make([]uint, 0, len(items))
+		for _, item := range items {
+			projectIDs = append(projectIDs, item.ProjectID)
+		}
+		var projects []models.Project
+		database.DB.Select("id", "name").Where("id IN ?", projectIDs).Find(&projects)
+		names := make(map[uint]string, len(projects))
+		for _, p := range projects {
+			names[p.ID] = p.Name
+		}
+		for i := range items {
+			items[i].ProjectName = names[items[i].ProjectID]
+		}
+	}
+
+	return items, total, nil
+}
+
+func (s *TrashService) Restore(itemType string, id uint) (*TrashRestoreResult, error) {
+	switch itemType {
+	case TrashTypeProject:
+		return s.restoreProject(id)
+	case TrashTypeCard:
+		return s.restoreCard(id)
+	case TrashTypeCloudVar:
+		return s.restoreCloudVar(id)
+	}
+	return nil, ErrTrashInvalidType
+}
+
+// restoreProject 恢复项目，以及删除时间不早于项目的模板、卡密、云变量、解绑记录和公告，已注销的会话不恢复
+func (s *TrashService) restoreProject(id uint) (*TrashRestoreResult, error) {
+	var project models.Project
+	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&project, id).Error; err != nil {
+		return nil, ErrTrashNotFound
+	}
+	deletedAt := project.DeletedAt.Time
+
+	var cloudVars []models.CloudVar
+	if err := database.DB.Unscoped().Where("project_id = ? AND deleted_at >= ?", id, deletedAt).Find(&cloudVars).Error; err != nil {
+		return nil, err
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Unscoped().Model(&models.Project{}).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	result := &TrashRestoreResult{CloudVars: int64(len(cloudVars))}
+	templates := tx.Unscoped().Model(&models.CardTemplate{}).Where("project_id = ? AND deleted_at >= ?", id, deletedAt).Update("deleted_at", nil)
+	if templates.Error != nil {
+		tx.Rollback()
+		return nil, templates.Error
+	}
+	result.Templates = templates.RowsAffected
+	cards := tx.Unscoped().Model(&models.Card{}).Where("project_id = ? AND deleted_at >= ?", id, deletedAt).Update("deleted_at", nil)
+	if cards.Error != nil {
+		tx.Rollback()
+		return nil, cards.Error
+	}
+	result.Cards = cards.RowsAffected
+	if err := tx.Unscoped().Model(&models.UnbindRecord{}).
+		Where("card_id IN (?) AND deleted_at >= ?", tx.Model(&models.Card{}).Select("id").Where("project_id = ?", id), deletedAt).
+		Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := restoreCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Unscoped().Model(&models.Announcement{}).Where("project_id = ? AND deleted_at >= ?", id, deletedAt).Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	middleware.InvalidateProjectCache(id)
+	return result, nil
+}
+
+// restoreCard 恢复卡密及随其删除的卡密级变量和消息，所属项目需未删除
+func (s *TrashService) restoreCard(id uint) (*TrashRestoreResult, error) {
+	var card models.Card
+	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&card, id).Error; err != nil {
+		return nil, ErrTrashNotFound
+	}
+	var project models.Project
+	if err := database.DB.First(&project, card.ProjectID).Error; err != nil {
+		return nil, errors.New("所属项目已删除，请先恢复项目")
+	}
+
+	var cloudVars []models.CloudVar
+	if err := database.DB.Unscoped().Where("card_id = ? AND deleted_at >= ?", id, card.DeletedAt.Time).Find(&cloudVars).Error; err != nil {
+		return nil, err
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Unscoped().Model(&models.Card{}).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := restoreCloudVars(tx, cloudVars); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Unscoped().Model(&models.Announcement{}).Where("card_id = ? AND deleted_at >= ?", id, card.DeletedAt.Time).Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &TrashRestoreResult{Cards: 1, CloudVars: int64(len(cloudVars))}, nil
+}
+
+// restoreCloudVar 恢复单个变量，作用域内已有同名变量时拒绝
+func (s *TrashService) restoreCloudVar(id uint) (*TrashRestoreResult, error) {
+	var cloudVar models.CloudVar
+	if err := database.DB.Unscoped().Where("deleted_at IS NOT NULL").First(&cloudVar, id).Error; err != nil {
+		return nil, ErrTrashNotFound
+	}
+	var project models.Project
+	if err := database.DB.First(&project, cloudVar.ProjectID).Error; err != nil {
+		return nil, errors.New("所属项目已删除，请先恢复项目")
+	}
+	if cloudVar.TemplateID != nil {
+		var template models.CardTemplate
+		if err := database.DB.First(&template, *cloudVar.TemplateID).Error; err != nil {
+			return nil, errors.New("所属模板已删除，无法恢复")
+		}
+	}
+	if cloudVar.CardID != nil {
+		var card models.Card
+		if err := database.DB.First(&card, *cloudVar.CardID).Error; err != nil {
+			return nil, errors.New("所属卡密已删除，请先恢复卡密")
+		}
+	}
+	var count int64
+	if err := scopedVars(database.DB, cloudVar.ProjectID, cloudVar.TemplateID, cloudVar.CardID).
+		Where("key = ?", cloudVar.Key).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("已存在同名变量 " + cloudVar.Key + "，无法恢复")
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := restoreCloudVars(tx, []models.CloudVar{cloudVar}); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &TrashRestoreResult{CloudVars: 1}, nil
+}
+
+// Purge 彻底删除回收站中的记录及其全部下级数据，不可恢复
+func (s *TrashService) Purge(itemType string, id uint) error {
+	var model interface{}
+	switch itemType {
+	case TrashTypeProject:
+		model = &models.Project{}
+	case TrashTypeCard:
+		model = &models.Card{}
+	case TrashTypeCloudVar:
+		model = &models.CloudVar{}
+	default:
+		return ErrTrashInvalidType
+	}
+	var count int64
+	if err := database.DB.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrTrashNotFound
+	}
+
+	tx := database.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var err error
+	switch itemType {
+	case TrashTypeProject:
+		err = purgeProjects(tx, []uint{id})
+	case TrashTypeCard:
+		err = purgeCards(tx, []uint{id})
+	case TrashTypeCloudVar:
+		err = purgeCloudVars(tx, []uint{id})
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if itemType == TrashTypeProject {
+		middleware.InvalidateProjectCache(id)
+	}
+	return nil
+}
+
+// PurgeExpired 彻底删除超过保留期的记录，返回删除的顶层记录数
+func (s *TrashService) PurgeExpired() (int, error) {
+	cutoff := time.Now().AddDate(0, 0, -trashRetentionDays)
+	purged := 0
+	steps := []struct {
+		model interface{}
+		purge func(tx *gorm.DB, ids []uint) error
+	}{
+		{&models.Project{}, purgeProjects},
+		{&models.Card{}, purgeCards},
+		{&models.CloudVar{}, purgeCloudVars},
+	}
+	for _, step := range steps {
+		var ids []uint
+		if err := database.DB.Unscoped().Model(step.model).Where("deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		tx := database.DB.Begin()
+		if err := step.purge(tx, ids); err != nil {
+			tx.Rollback()
+			return purged, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+	}
+	return purged, nil
+}
+
+// StartTrashCleaner 启动时及之后每小时清理一次过期的回收站记录
+func StartTrashCleaner() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		trashSvc := NewTrashService()
+		for {
+			if purged, err := trashSvc.PurgeExpired(); err != nil {
+				log.Printf("回收站清理失败: %v", err)
+			} else if purged > 0 {
+				log.Printf("回收站清理完成: 彻底删除 %d 条过期记录", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func purgeCloudVars(tx *gorm.DB, ids []uint) error {
+	if err := tx.Where("cloud_var_id IN ?", ids).Delete(&models.CloudVarVersion{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.CloudVar{}).Error
+}
+
+// purgeCards 删除卡密及会话、解绑记录、用量、离线授权、卡密级变量和消息
+func purgeCards(tx *gorm.DB, ids []uint) error {
+	var varIDs []uint
+	if err := tx.Unscoped().Model(&models.CloudVar{}).Where("card_id IN ?", ids).Pluck("id", &varIDs).Error; err != nil {
+		return err
+	}
+	if len(varIDs) > 0 {
+		if err := purgeCloudVars(tx, varIDs); err != nil {
+			return err
+		}
+	}
+	var announcementIDs []uint
+	if err := tx.Unscoped().Model(&models.Announcement{}).Where("card_id IN ?", ids).Pluck("id", &announcementIDs).Error; err != nil {
+		return err
+	}
+	if len(announcementIDs) > 0 {
+		if err := purgeAnnouncements(tx, announcementIDs); err != nil {
+			return err
+		}
+	}
+	for _, model := range []interface{}{&models.Token{}, &models.UnbindRecord{}, &models.UsageCounter{}, &models.OfflineLicense{}, &models.AnnouncementRead{}} {
+		if err := tx.Unscoped().Where("card_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Card{}).Error
+}
+
+// purgeProjects 删除项目及其下全部卡密、模板、云变量、公告、会话和密钥
+func purgeProjects(tx *gorm.DB, ids []uint) error {
+	var cardIDs []uint
+	if err := tx.Unscoped().Model(&models.Card{}).Where("project_id IN ?", ids).Pluck("id", &cardIDs).Error; err != nil {
+		return err
+	}
+	if len(cardIDs) > 0 {
+		if err := purgeCards(tx, cardIDs); err != nil {
+			return err
+		}
+	}
+	var varIDs []uint
+	if err := tx.Unscoped().Model(&models.CloudVar{}).Where("project_id IN ?", ids).Pluck("id", &varIDs).Error; err != nil {
+		return err
+	}
+	if len(varIDs) > 0 {
+		if err := purgeCloudVars(tx, varIDs); err != nil {
+			return err
+		}
+	}
+	var announcementIDs []uint
+	if err := tx.Unscoped().Model(&models.Announcement{}).Where("project_id IN ?", ids).Pluck("id", &announcementIDs).Error; err != nil {
+		return err
+	}
+	if len(announcementIDs) > 0 {
+		if err := purgeAnnouncements(tx, announcementIDs); err != nil {
+			return err
+		}
+	}
+	for _, model := range []interface{}{
+		&models.Token{}, &models.CardTemplate{}, &models.OfflineLicense{},
+		&models.ProjectKey{}, &models.CryptoSession{}, &models.CloudVarRevision{},
+	} {
+		if err := tx.Unscoped().Where("project_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Project{}).Error
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrQuotaExceeded 本周期配额已用尽，客户端应阻止对应操作
+var ErrQuotaExceeded = errors.New("配额已用尽")
+
+type UsageService struct{}
+
+func NewUsageService() *UsageService {
+	return &UsageService{}
+}
+
+type ReportUsageRequest struct {
+	Meter  string `json:"meter"`
+	Amount int64  `json:"amount"` // 为空时按1计
+}
+
+type UsageBalance struct {
+	Meter     string     `json:"meter"`
+	Period    string     `json:"period"`
+	Limit     int64      `json:"limit"` // -1 表示不限制
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"` // 不限制时为 -1
+	ResetAt   *time.Time `json:"reset_at"`  // total 周期为空
+}
+
+func validateMeters(meters models.MeterLimits) error {
+	for name, limit := range meters {
+		if name == "" {
+			return errors.New("计量项名称不能为空")
+		}
+		if !limit.Valid() {
+			return fmt.Errorf("计量项 %s 配置无效，period 须为 daily/monthly/total，limit 不小于 -1", name)
+		}
+	}
+	return nil
+}
+
+func newUsageBalance(meter string, limit models.MeterLimit, used int64, now time.Time) *UsageBalance {
+	b := &UsageBalance{
+		Meter:     meter,
+		Period:    limit.Period,
+		Limit:     limit.Limit,
+		Used:      used,
+		Remaining: -1,
+		ResetAt:   limit.ResetAt(now),
+	}
+	if limit.Limit >= 0 {
+		b.Remaining = max(limit.Limit-used, 0)
+	}
+	return b
+}
+
+func (s *UsageService) cardMeters(cardID uint) (models.MeterLimits, error) {
+	var card models.Card
+	if err := database.DB.First(&card, cardID).Error; err != nil {
+		return nil, errors.New("卡密不存在")
+	}
+	return resolveCardEntitlements(&card).Meters, nil
+}
+
+// Report 记录一次用量并返回本周期余额，超出配额时不计入并返回 ErrQuotaExceeded
+func (s *UsageService) Report(cardID uint, req *ReportUsageRequest) (*UsageBalance, error) {
+	if req.Amount == 0 {
+		req.Amount = 1
+	}
+	if req.Amount < 0 {
+		return nil, errors.New("用量不能为负数")
+	}
+
+	meters, err := s.cardMeters(cardID)
+	if err != nil {
+		return nil, err
+	}
+	limit, ok := meters[req.Meter]
+	if !ok {
+		return nil, errors.New("未定义的计量项")
+	}
+
+	now := time.Now()
+	periodKey := limit.PeriodKey(now)
+
+	// 确保计数行存在，并发插入由唯一索引去重
+	counter := models.UsageCounter{CardID: cardID, Meter: req.Meter, PeriodKey: periodKey}
+	if err := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return nil, err
+	}
+
+	// 单条UPDATE完成跨周期清零、累加和配额判断，避免先查后写的竞争
+	next := gorm.Expr("CASE WHEN period_key = ? THEN used + ? ELSE ? END", periodKey, req.Amount, req.Amount)
+	query := database.DB.Model(&models.UsageCounter{}).Where("card_id = ? AND meter = ?", cardID, req.Meter)
+	if limit.Limit >= 0 {
+		query = query.Where("CASE WHEN period_key = ? THEN used + ? ELSE ? END <= ?", periodKey, req.Amount, req.Amount, limit.Limit)
+	}
+	result := query.Updates(map[string]interface{}{
+		"used":       next,
+		"period_key": periodKey,
+		"updated_at": now,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if err := database.DB.Where("card_id = ? AND meter = ?", cardID, req.Meter).First(&counter).Error; err != nil {
+		return nil, err
+	}
+	used := counter.Used
+	if counter.PeriodKey != periodKey {
+		used = 0
+	}
+	balance := newUsageBalance(req.Meter, limit, used, now)
+
+	if result.RowsAffected == 0 {
+		return balance, ErrQuotaExceeded
+	}
+	return balance, nil
+}
+
+// Balances 返回卡密所有计量项的本周期余额
+func (s *UsageService) Balances(cardID uint) ([]UsageBalance, error) {
+	meters, err := s.cardMeters(cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []models.UsageCounter
+	if err := database.DB.Where("card_id = ?", cardID).Find(&counters).Error; err != nil {
+		return nil, err
+	}
+	usedByMeter := make(map[string]models.UsageCounter, len(counters))
+	for _, counter := range counters {
+		usedByMeter[counter.Meter] = counter
+	}
+
+	now := time.Now()
+	balances := make([]UsageBalance, 0, len(meters))
+	for name, limit := range meters {
+		var used int64
+		if counter, ok := usedByMeter[name]; ok && counter.PeriodKey == limit.PeriodKey(now) {
+			used = counter.Used
+		}
+		balances = append(balances, *newUsageBalance(name, limit, used, now))
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Meter < balances[j].Meter
+	})
+
+	return balances, nil
+}
+
+// Reset 管理员清零卡密某个计量项的当前用量
+func (s *UsageService) Reset(cardID uint, meter string) error {
+	return database.DB.Model(&models.UsageCounter{}).
+		Where("card_id = ? AND meter = ?", cardID, meter).
+		Update("used", 0).Error
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"time"
+
+	"github.com/nextkey/nextkey/backend/internal/database"
+	"github.com/nextkey/nextkey/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DatabaseStore 基于数据库的存储，重启后保留状态，连接同一数据库的实例共享限额
+// 过期记录由 database 包的定时任务清理
+type DatabaseStore struct {
+	db *gorm.DB
+}
+
+func NewDatabaseStore(db *gorm.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (s *DatabaseStore) Take(key string, bucket Bucket) (TakeResult, error) {
+	var result TakeResult
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var state models.RateLimitBucket
+		err := tx.Where("key = ?", key).First(&state).Error
+		if err != nil {
+			if !database.IsNotFound(err) {
+				return err
+			}
+			state = models.RateLimitBucket{
+				Key:    key,
+				Tokens: bucket.Capacity(),
+				LastAt: now.UnixNano(),
+			}
+		}
+
+		state.Tokens, result = take(state.Tokens, time.Unix(0, state.LastAt), now, bucket)
+		state.LastAt = now.UnixNano()
+		state.ExpireAt = now.Add(bucket.FillTime()).Unix()
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&state).Error
+	})
+
+	return result, err
+}
+
+// Remember 每次调用写入一行，过期记录由定时任务按创建时间清理，ttl 仅作接口兼容
+func (s *DatabaseStore) Remember(key string, ttl time.Duration) (bool, error) {
+	// 依赖唯一约束判重，避免并发请求同时通过检查
+	nonce := models.Nonce{Nonce: key}
+	if err := s.db.Create(&nonce).Error; err != nil {
+		if database.IsDuplicateError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+	idle   time.Duration
+}
+
+// MemoryStore 进程内存储，适用于单实例部署，重启后状态丢失
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+
+	*ReplayCache
+}
+
+// NewMemoryStore nonceRetention 为nonce保留时长，应覆盖请求时间戳的全部有效范围
+func NewMemoryStore(nonceRetention time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		buckets:     make(map[string]*memoryBucket),
+		ReplayCache: NewReplayCache(nonceRetention),
+	}
+
+	// 定期清理已回满的桶
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.cleanup()
+		}
+	}()
+
+	return s
+}
+
+func (s *MemoryStore) Take(key string, bucket Bucket) (TakeResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &memoryBucket{tokens: bucket.Capacity(), last: now}
+		s.buckets[key] = b
+	}
+
+	tokens, result := take(b.tokens, b.last, now, bucket)
+	b.tokens = tokens
+	b.last = now
+	b.idle = bucket.FillTime()
+	return result, nil
+}
+
+func (s *MemoryStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, b := range s.buckets {
+		// 空闲超过回满时间的桶与新建等价
+		if now.Sub(b.last) > b.idle {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/pkg/config"
+)
+
+const (
+	redisDialTimeout = 3 * time.Second
+	redisIOTimeout   = 2 * time.Second
+)
+
+// 令牌桶在服务端原子计算，避免多实例并发读写同一个桶
+// KEYS[1]=桶  ARGV: 容量, 每秒速率, 当前毫秒时间戳, 过期毫秒数
+const tokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 't', 'l')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+  tokens = capacity
+  last = now
+end
+if now > last then
+  tokens = math.min(capacity, tokens + (now - last) / 1000 * rate)
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HMSET', KEYS[1], 't', tostring(tokens), 'l', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`
+
+var tokenBucketSHA = func() string {
+	sum := sha1.Sum([]byte(tokenBucketScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisStore 基于 Redis 兼容服务（Redis/KeyDB/Valkey 等）的存储，多实例共享状态
+// 令牌桶依赖 EVAL 脚本支持
+type RedisStore struct {
+	cfg  config.RedisConfig
+	pool chan *respConn
+}
+
+func NewRedisStore(cfg config.RedisConfig) (*RedisStore, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("未配置Redis地址")
+	}
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 16
+	}
+
+	s := &RedisStore{
+		cfg:  cfg,
+		pool: make(chan *respConn, poolSize),
+	}
+
+	// 启动时检查连通性，配置错误尽早暴露
+	if _, err := s.do("PING"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Take(key string, bucket Bucket) (TakeResult, error) {
+	now := time.Now()
+	args := []string{
+		s.cfg.Prefix + "rl:" + key,
+		strconv.FormatFloat(bucket.Capacity(), 'f', -1, 64),
+		strconv.FormatFloat(bucket.Rate(), 'f', -1, 64),
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.FormatInt(bucket.FillTime().Milliseconds()+1000, 10),
+	}
+
+	reply, err := s.do(append([]string{"EVALSHA", tokenBucketSHA, "1"}, args...)...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		reply, err = s.do(append([]string{"EVAL", tokenBucketScript, "1"}, args...)...)
+	}
+	if err != nil {
+		return TakeResult{}, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return TakeResult{}, errors.New("无效的限流脚本返回值")
+	}
+	allowed, _ := items[0].(int64)
+	tokensStr, _ := items[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return TakeResult{}, err
+	}
+
+	if allowed == 1 {
+		return TakeResult{Allowed: true, Remaining: int(tokens)}, nil
+	}
+
+	var retryAfter time.Duration
+	if rate := bucket.Rate(); rate > 0 {
+		retryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return TakeResult{Allowed: false, RetryAfter: retryAfter}, nil
+}
+
+func (s *RedisStore) Remember(key string, ttl time.Duration) (bool, error) {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	reply, err := s.do("SET", s.cfg.Prefix+"nonce:"+key, "1", "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		return false, err
+	}
+	// NX 条件不满足时返回空回复
+	return reply != nil, nil
+}
+
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.Do(redisIOTimeout, args...)
+	if err != nil {
+		if _, ok := err.(respError); !ok {
+			// 网络错误后连接状态未知，直接丢弃
+			conn.Close()
+			return nil, err
+		}
+	}
+	s.put(conn)
+	return reply, err
+}
+
+func (s *RedisStore) get() (*respConn, error) {
+	select {
+	case conn := <-s.pool:
+		return conn, nil
+	default:
+	}
+
+	conn, err := dialRESP(s.cfg.Addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Password != "" {
+		if _, err := conn.Do(redisIOTimeout, "AUTH", s.cfg.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.cfg.DB != 0 {
+		if _, err := conn.Do(redisIOTimeout, "SELECT", strconv.Itoa(s.cfg.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *RedisStore) put(conn *respConn) {
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/pkg/config"
+)
+
+// respStub 进程内的 RESP 服务，只实现 RedisStore 用到的命令
+// 令牌桶脚本按 tokenBucketScript 的逻辑在 Go 中计算，EVALSHA 在脚本未通过 EVAL 加载前返回 NOSCRIPT
+type respStub struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	loaded  bool
+	buckets map[string][2]float64 // 令牌数, 上次时间(毫秒)
+	keys    map[string]time.Time  // 过期时间
+	calls   map[string]int
+}
+
+func newRESPStub(t *testing.T) *respStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &respStub{
+		ln:      ln,
+		buckets: map[string][2]float64{},
+		keys:    map[string]time.Time{},
+		calls:   map[string]int{},
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *respStub) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *respStub) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, s.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func (s *respStub) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+	s.calls[cmd]++
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "EVALSHA":
+		if !s.loaded || args[1] != tokenBucketSHA {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+		return s.tokenBucket(args[3:])
+	case "EVAL":
+		if args[1] != tokenBucketScript {
+			return "-ERR unknown script\r\n"
+		}
+		s.loaded = true
+		return s.tokenBucket(args[3:])
+	case "SET":
+		// SET key value NX PX ms
+		key := args[1]
+		if expire, ok := s.keys[key]; ok && time.Now().Before(expire) {
+			return "$-1\r\n"
+		}
+		ms, _ := strconv.ParseInt(args[5], 10, 64)
+		s.keys[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return "+OK\r\n"
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// tokenBucket ARGV 同 tokenBucketScript: 键, 容量, 每秒速率, 当前毫秒时间戳, 过期毫秒数
+func (s *respStub) tokenBucket(args []string) string {
+	key := args[0]
+	capacity, _ := strconv.ParseFloat(args[1], 64)
+	rate, _ := strconv.ParseFloat(args[2], 64)
+	now, _ := strconv.ParseFloat(args[3], 64)
+
+	state, ok := s.buckets[key]
+	tokens, last := state[0], state[1]
+	if !ok {
+		tokens, last = capacity, now
+	}
+	if now > last {
+		tokens = math.Min(capacity, tokens+(now-last)/1000*rate)
+	}
+	allowed := 0
+	if tokens >= 1 {
+		tokens--
+		allowed = 1
+	}
+	s.buckets[key] = [2]float64{tokens, now}
+	return "*2\r\n:" + strconv.Itoa(allowed) + "\r\n" + bulk(strconv.FormatFloat(tokens, 'f', -1, 64))
+}
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *respStub) {
+	stub := newRESPStub(t)
+	s, err := NewRedisStore(config.RedisConfig{Addr: stub.ln.Addr().String(), Prefix: "test:", PoolSize: 2})
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	return s, stub
+}
+
+func TestRedisStoreTake(t *testing.T) {
+	s, stub := newTestRedisStore(t)
+	bucket := Bucket{Limit: 2, Window: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Take("ip:1", bucket)
+		if err != nil {
+			t.Fatalf("Take #%d: %v", i, err)
+		}
+		if !res.Allowed || res.Remaining != 1-i {
+			t.Fatalf("Take #%d = %+v, want allowed with %d remaining", i, res, 1-i)
+		}
+	}
+
+	res, err := s.Take("ip:1", bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("third request within the window was allowed")
+	}
+	// 每30秒补充一个令牌，桶刚耗尽时需等待接近30秒
+	if res.RetryAfter <= 25*time.Second || res.RetryAfter > 30*time.Second {
+		t.Fatalf("RetryAfter = %v, want about 30s", res.RetryAfter)
+	}
+
+	// 不同的键使用各自的桶
+	if res, err := s.Take("ip:2", bucket); err != nil || !res.Allowed {
+		t.Fatalf("Take other key = %+v, %v", res, err)
+	}
+
+	// 首次 EVALSHA 返回 NOSCRIPT 后改用 EVAL，此后都走 EVALSHA
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.calls["EVAL"] != 1 || stub.calls["EVALSHA"] != 4 {
+		t.Fatalf("EVAL calls = %d, EVALSHA calls = %d, want 1 and 4", stub.calls["EVAL"], stub.calls["EVALSHA"])
+	}
+}
+
+func TestRedisStoreRemember(t *testing.T) {
+	s, _ := newTestRedisStore(t)
+	ttl := 100 * time.Millisecond
+
+	if ok, err := s.Remember("n1", ttl); err != nil || !ok {
+		t.Fatalf("first Remember = %v, %v", ok, err)
+	}
+	if ok, err := s.Remember("n1", ttl); err != nil || ok {
+		t.Fatalf("replayed Remember = %v, %v, want rejected", ok, err)
+	}
+	if ok, err := s.Remember("n2", ttl); err != nil || !ok {
+		t.Fatalf("other nonce Remember = %v, %v", ok, err)
+	}
+
+	time.Sleep(ttl + 50*time.Millisecond)
+	if ok, err := s.Remember("n1", ttl); err != nil || !ok {
+		t.Fatalf("Remember after expiry = %v, %v", ok, err)
+	}
+}
+
+func TestRedisStoreErrorReply(t *testing.T) {
+	s, _ := newTestRedisStore(t)
+
+	// 错误回复不是网络错误，连接应放回连接池继续使用
+	if _, err := s.do("FLUSHALL"); err == nil {
+		t.Fatal("unknown command returned no error")
+	} else if _, ok := err.(respError); !ok {
+		t.Fatalf("err = %T %v, want respError", err, err)
+	}
+	if reply, err := s.do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("PING after error = %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	replayShardCount = 32
+	// 保留时长被切分的时间桶数，桶越多过期越精确，查询时需要检查的桶也越多
+	replaySlotsPerWindow = 8
+)
+
+type replaySlot struct {
+	epoch  int64
+	nonces map[string]struct{}
+}
+
+type replayShard struct {
+	mu    sync.Mutex
+	slots []replaySlot
+}
+
+// ReplayCache 分片、按时间分桶的nonce缓存
+// 每个时间桶整体过期，写入时复用过期桶，不需要逐条清理
+// 记录至少保留 retention，至多保留 retention 加一个桶宽
+type ReplayCache struct {
+	width     time.Duration
+	retention time.Duration
+	shards    [replayShardCount]replayShard
+}
+
+func NewReplayCache(retention time.Duration) *ReplayCache {
+	width := retention / replaySlotsPerWindow
+	if width < time.Second {
+		width = time.Second
+	}
+	slotCount := int((retention+width-1)/width) + 1
+
+	rc := &ReplayCache{
+		width:     width,
+		retention: retention,
+	}
+	for i := range rc.shards {
+		rc.shards[i].slots = make([]replaySlot, slotCount)
+		for j := range rc.shards[i].slots {
+			rc.shards[i].slots[j] = replaySlot{
+				epoch:  -1,
+				nonces: make(map[string]struct{}),
+			}
+		}
+	}
+	return rc
+}
+
+// Remember 记录nonce，保留期内重复记录返回false
+// 实际保留时长由构造时的 retention 决定，ttl 超出 retention 时按 retention 处理
+func (rc *ReplayCache) Remember(key string, ttl time.Duration) (bool, error) {
+	return rc.remember(key, rc.epoch(time.Now())), nil
+}
+
+func (rc *ReplayCache) remember(key string, current int64) bool {
+	shard := &rc.shards[shardIndex(key)]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	oldest := current - int64(len(shard.slots)) + 1
+	for i := range shard.slots {
+		slot := &shard.slots[i]
+		if slot.epoch < oldest {
+			continue
+		}
+		if _, exists := slot.nonces[key]; exists {
+			return false
+		}
+	}
+
+	slot := &shard.slots[current%int64(len(shard.slots))]
+	if slot.epoch != current {
+		clear(slot.nonces)
+		slot.epoch = current
+	}
+	slot.nonces[key] = struct{}{}
+	return true
+}
+
+func (rc *ReplayCache) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(rc.width)
+}
+
+// shardIndex FNV-1a 哈希，避免转换为[]byte产生分配
+func shardIndex(key string) int {
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % replayShardCount)
+}
+
+type replaySnapshot struct {
+	Width  int64
+	Epochs []int64
+	Nonces [][]string
+}
+
+// SaveFile 将未过期的记录写入文件，用于重启后继续拒绝窗口内的重放请求
+func (rc *ReplayCache) SaveFile(path string) error {
+	snapshot := replaySnapshot{Width: int64(rc.width)}
+	current := rc.epoch(time.Now())
+
+	for i := range rc.shards {
+		shard := &rc.shards[i]
+		shard.mu.Lock()
+		oldest := current - int64(len(shard.slots)) + 1
+		for _, slot := range shard.slots {
+			if slot.epoch < oldest || len(slot.nonces) == 0 {
+				continue
+			}
+			nonces := make([]string, 0, len(slot.nonces))
+			for nonce := range slot.nonces {
+				nonces = append(nonces, nonce)
+			}
+			snapshot.Epochs = append(snapshot.Epochs, slot.epoch)
+			snapshot.Nonces = append(snapshot.Nonces, nonces)
+		}
+		shard.mu.Unlock()
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(&snapshot); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadFile 从文件恢复记录，文件不存在时忽略，已过期的记录直接丢弃
+func (rc *ReplayCache) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var snapshot replaySnapshot
+	if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+		return err
+	}
+	if snapshot.Width <= 0 || len(snapshot.Epochs) != len(snapshot.Nonces) {
+		return errors.New("nonce缓存文件格式错误")
+	}
+
+	// 保存时的桶宽可能与当前配置不同，按时间换算到当前的桶
+	now := time.Now()
+	for i, epoch := range snapshot.Epochs {
+		savedAt := time.Unix(0, epoch*snapshot.Width)
+		if now.Sub(savedAt) > rc.retention+time.Duration(snapshot.Width) {
+			continue
+		}
+		target := rc.epoch(savedAt)
+		for _, nonce := range snapshot.Nonces[i] {
+			rc.restore(nonce, target, rc.epoch(now))
+		}
+	}
+	return nil
+}
+
+func (rc *ReplayCache) restore(key string, target, current int64) {
+	shard := &rc.shards[shardIndex(key)]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if target <= current-int64(len(shard.slots)) {
+		return
+	}
+	slot := &shard.slots[target%int64(len(shard.slots))]
+	if slot.epoch > target {
+		return
+	}
+	if slot.epoch != target {
+		clear(slot.nonces)
+		slot.epoch = target
+	}
+	slot.nonces[key] = struct{}{}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayCacheRejectsWithinWindow(t *testing.T) {
+	rc := NewReplayCache(NonceRetention(300))
+
+	if ok, _ := rc.Remember("n1", 0); !ok {
+		t.Fatal("first nonce rejected")
+	}
+	if ok, _ := rc.Remember("n1", 0); ok {
+		t.Fatal("replayed nonce accepted")
+	}
+	if ok, _ := rc.Remember("n2", 0); !ok {
+		t.Fatal("other nonce rejected")
+	}
+}
+
+func TestReplayCacheAcceptsAfterExpiry(t *testing.T) {
+	rc := NewReplayCache(8 * time.Second)
+	slots := int64(len(rc.shards[0].slots))
+	start := rc.epoch(time.Now())
+
+	if !rc.remember("n1", start) {
+		t.Fatal("first nonce rejected")
+	}
+	// 保留期内的最后一个桶仍然拒绝
+	if rc.remember("n1", start+slots-1) {
+		t.Fatal("nonce accepted inside the retention window")
+	}
+	if !rc.remember("n1", start+slots) {
+		t.Fatal("nonce rejected after the retention window")
+	}
+}
+
+func TestReplayCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces.dat")
+	rc := NewReplayCache(NonceRetention(300))
+	rc.Remember("n1", 0)
+	rc.Remember("n2", 0)
+	if err := rc.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+
+	restored := NewReplayCache(NonceRetention(300))
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	for _, nonce := range []string{"n1", "n2"} {
+		if ok, _ := restored.Remember(nonce, 0); ok {
+			t.Fatalf("nonce %s accepted after restart", nonce)
+		}
+	}
+	if ok, _ := restored.Remember("n3", 0); !ok {
+		t.Fatal("new nonce rejected after restart")
+	}
+}
+
+func TestReplayCacheLoadMissingFile(t *testing.T) {
+	rc := NewReplayCache(NonceRetention(300))
+	if err := rc.LoadFile(filepath.Join(t.TempDir(), "missing.dat")); err != nil {
+		t.Fatalf("LoadFile on missing file: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nextkey/nextkey/backend/pkg/config"
+	"gorm.io/gorm"
+)
+
+// Bucket 令牌桶参数
+type Bucket struct {
+	Limit  int           // 每个窗口补充的令牌数
+	Window time.Duration // 补充周期
+	Burst  int           // 桶容量，<=0 时等于 Limit
+}
+
+// Capacity 返回桶容量
+func (b Bucket) Capacity() float64 {
+	if b.Burst > 0 {
+		return float64(b.Burst)
+	}
+	return float64(b.Limit)
+}
+
+// Rate 返回每秒补充的令牌数
+func (b Bucket) Rate() float64 {
+	if b.Window <= 0 {
+		return 0
+	}
+	return float64(b.Limit) / b.Window.Seconds()
+}
+
+// FillTime 返回空桶回满所需时间，超过此时间未访问的桶可安全丢弃
+func (b Bucket) FillTime() time.Duration {
+	rate := b.Rate()
+	if rate <= 0 {
+		return b.Window
+	}
+	return time.Duration(b.Capacity() / rate * float64(time.Second))
+}
+
+// TakeResult 一次取令牌的结果
+type TakeResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// RateLimiter 限流存储接口
+// 多实例部署时替换为共享后端即可让限额在实例间生效
+type RateLimiter interface {
+	Take(key string, bucket Bucket) (TakeResult, error)
+}
+
+// NonceStore 防重放存储接口
+type NonceStore interface {
+	// Remember 记录key并保留ttl，key已存在时返回false
+	Remember(key string, ttl time.Duration) (bool, error)
+}
+
+// Store 同时提供限流和防重放能力的存储后端
+type Store interface {
+	RateLimiter
+	NonceStore
+}
+
+// take 在给定的桶状态上执行一次令牌桶计算，返回新的令牌数
+func take(tokens float64, last time.Time, now time.Time, bucket Bucket) (float64, TakeResult) {
+	capacity := bucket.Capacity()
+	rate := bucket.Rate()
+
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens += elapsed * rate
+	}
+	if tokens > capacity {
+		tokens = capacity
+	}
+
+	if tokens >= 1 {
+		tokens--
+		return tokens, TakeResult{Allowed: true, Remaining: int(tokens)}
+	}
+
+	var retryAfter time.Duration
+	if rate > 0 {
+		retryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return tokens, TakeResult{Allowed: false, RetryAfter: retryAfter}
+}
+
+const (
+	BackendMemory   = "memory"
+	BackendDatabase = "database"
+	BackendRedis    = "redis"
+)
+
+// NonceRetention 根据防重放窗口计算nonce需要保留的时长
+// timestamp允许前后各偏移一个窗口，nonce需保留两个窗口才能覆盖全部有效期
+func NonceRetention(replayWindowSeconds int) time.Duration {
+	if replayWindowSeconds <= 0 {
+		replayWindowSeconds = 300
+	}
+	return time.Duration(2*replayWindowSeconds) * time.Second
+}
+
+// New 按名称创建存储后端
+func New(backend string, cfg *config.Config, db *gorm.DB) (Store, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewMemoryStore(NonceRetention(cfg.Security.ReplayWindow)), nil
+	case BackendDatabase:
+		if db == nil {
+			return nil, errors.New("数据库未初始化")
+		}
+		return NewDatabaseStore(db), nil
+	case BackendRedis:
+		return NewRedisStore(cfg.Store.Redis)
+	default:
+		return nil, errors.New("不支持的存储后端: " + backend)
+	}
+}
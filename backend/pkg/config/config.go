@@ -10,10 +10,13 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Security SecurityConfig `yaml:"security"`
-	Admin    AdminConfig    `yaml:"admin"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Security  SecurityConfig  `yaml:"security"`
+	Admin     AdminConfig     `yaml:"admin"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Store     StoreConfig     `yaml:"store"`
+	Trash     TrashConfig     `yaml:"trash"`
 }
 
 type ServerConfig struct {
@@ -29,6 +32,14 @@ type SecurityConfig struct {
 	JWTSecret    string `yaml:"jwt_secret"`
 	TokenExpire  int    `yaml:"token_expire"`
 	ReplayWindow int    `yaml:"replay_window"`
+	// 关闭后，未携带Token且外层未指明 project_uuid 的加密请求直接拒绝，不再逐个项目尝试解密
+	DisableTrialDecrypt bool `yaml:"disable_trial_decrypt"`
+	// 密钥轮换后旧密钥继续可用的时长(秒)，为0时新密钥激活后旧密钥立即失效，未配置时默认7天
+	KeyGracePeriod *int `yaml:"key_grace_period"`
+	// 密钥交换方案握手会话的有效期(秒)
+	SessionTTL int `yaml:"session_ttl"`
+	// 登录租约过期后客户端在无法联网时可继续运行的时长(秒)
+	LeaseGrace int `yaml:"lease_grace"`
 }
 
 type AdminConfig struct {
@@ -36,6 +47,42 @@ type AdminConfig struct {
 	Password string `yaml:"password"`
 }
 
+// TrashConfig 回收站配置，删除的项目、卡密和云变量保留期满后彻底删除
+type TrashConfig struct {
+	RetentionDays int `yaml:"retention_days"`
+}
+
+// RateLimitConfig 限流配置，按路由分组配置规则
+type RateLimitConfig struct {
+	Rules map[string]RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule 单个路由分组的令牌桶规则
+type RateLimitRule struct {
+	Key    string `yaml:"key"`    // 限流维度: ip/card/token/project
+	Limit  int    `yaml:"limit"`  // 每个窗口允许的请求数，<=0 表示不限流
+	Window int    `yaml:"window"` // 窗口长度(秒)
+	Burst  int    `yaml:"burst"`  // 突发容量，默认等于limit
+}
+
+// StoreConfig 限流与防重放的存储后端配置
+// 多实例部署时应使用 database 或 redis，使限额和nonce在实例间共享
+type StoreConfig struct {
+	RateLimit string      `yaml:"rate_limit"` // memory/database/redis
+	Nonce     string      `yaml:"nonce"`      // memory/database/redis
+	NonceFile *string     `yaml:"nonce_file"` // memory 后端停机时保存nonce的文件，设为空字符串则不保存，未配置时使用默认文件
+	Redis     RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig Redis 兼容服务的连接配置
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"` // 键前缀，多个服务共用同一Redis时用于隔离
+	PoolSize int    `yaml:"pool_size"`
+}
+
 func Load() *Config {
 	configPath := "config.yaml"
 
@@ -56,6 +103,8 @@ func Load() *Config {
 		log.Fatalf("解析配置文件失败: %v", err)
 	}
 
+	applyDefaults(&cfg)
+
 	return &cfg
 }
 
@@ -69,17 +118,105 @@ func generateDefault() *Config {
 			Path: "./nextkey.db",
 		},
 		Security: SecurityConfig{
-			JWTSecret:    generateRandomKey(32),
-			TokenExpire:  3600,
-			ReplayWindow: 300,
+			JWTSecret:      generateRandomKey(32),
+			TokenExpire:    3600,
+			ReplayWindow:   300,
+			KeyGracePeriod: intPtr(604800),
+			SessionTTL:     86400,
+			LeaseGrace:     600,
 		},
 		Admin: AdminConfig{
 			Username: "admin",
 			Password: "admin123",
 		},
+		RateLimit: RateLimitConfig{
+			Rules: defaultRateLimitRules(),
+		},
+		Store: defaultStoreConfig(),
+		Trash: TrashConfig{
+			RetentionDays: 30,
+		},
+	}
+}
+
+// applyDefaults 为旧版配置文件中缺失的配置项补充默认值
+func applyDefaults(cfg *Config) {
+	if cfg.Security.KeyGracePeriod == nil || *cfg.Security.KeyGracePeriod < 0 {
+		cfg.Security.KeyGracePeriod = intPtr(604800)
+	}
+	if cfg.Security.SessionTTL <= 0 {
+		cfg.Security.SessionTTL = 86400
+	}
+	if cfg.Security.LeaseGrace <= 0 {
+		cfg.Security.LeaseGrace = 600
+	}
+	if cfg.Trash.RetentionDays <= 0 {
+		cfg.Trash.RetentionDays = 30
+	}
+	// 按分组补充，升级后新增的分组也会生效；需要关闭某个分组时将其 limit 设为 0
+	if cfg.RateLimit.Rules == nil {
+		cfg.RateLimit.Rules = map[string]RateLimitRule{}
+	}
+	for group, rule := range defaultRateLimitRules() {
+		if _, ok := cfg.RateLimit.Rules[group]; !ok {
+			cfg.RateLimit.Rules[group] = rule
+		}
+	}
+
+	defaults := defaultStoreConfig()
+	if cfg.Store.RateLimit == "" {
+		cfg.Store.RateLimit = defaults.RateLimit
+	}
+	if cfg.Store.Nonce == "" {
+		cfg.Store.Nonce = defaults.Nonce
+	}
+	if cfg.Store.NonceFile == nil {
+		cfg.Store.NonceFile = defaults.NonceFile
+	}
+	if cfg.Store.Redis.Addr == "" {
+		cfg.Store.Redis.Addr = defaults.Redis.Addr
+	}
+	if cfg.Store.Redis.Prefix == "" {
+		cfg.Store.Redis.Prefix = defaults.Redis.Prefix
+	}
+	if cfg.Store.Redis.PoolSize <= 0 {
+		cfg.Store.Redis.PoolSize = defaults.Redis.PoolSize
+	}
+}
+
+func defaultStoreConfig() StoreConfig {
+	return StoreConfig{
+		RateLimit: "memory",
+		Nonce:     "memory",
+		NonceFile: stringPtr("./nextkey_nonces.dat"),
+		Redis: RedisConfig{
+			Addr:     "127.0.0.1:6379",
+			Prefix:   "nextkey:",
+			PoolSize: 16,
+		},
+	}
+}
+
+func defaultRateLimitRules() map[string]RateLimitRule {
+	return map[string]RateLimitRule{
+		"login":       {Key: "ip", Limit: 5, Window: 60},
+		"handshake":   {Key: "ip", Limit: 20, Window: 60},
+		"admin_login": {Key: "ip", Limit: 5, Window: 60},
+		"unbind":      {Key: "ip", Limit: 10, Window: 60},
+		"heartbeat":   {Key: "token", Limit: 30, Window: 60},
+		"cloud_var":   {Key: "token", Limit: 120, Window: 60, Burst: 30},
+		"client":      {Key: "token", Limit: 60, Window: 60},
 	}
 }
 
+func intPtr(v int) *int {
+	return &v
+}
+
+func stringPtr(v string) *string {
+	return &v
+}
+
 func generateRandomKey(length int) string {
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func loadYAML(t *testing.T, data string) *Config {
+	t.Helper()
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	applyDefaults(&cfg)
+	return &cfg
+}
+
+func TestApplyDefaultsUpgradedConfig(t *testing.T) {
+	cfg := loadYAML(t, `
+rate_limit:
+  rules:
+    login: {key: ip, limit: 3, window: 60}
+`)
+	if *cfg.Security.KeyGracePeriod != 604800 {
+		t.Errorf("key_grace_period = %d, want default", *cfg.Security.KeyGracePeriod)
+	}
+	if *cfg.Store.NonceFile != "./nextkey_nonces.dat" {
+		t.Errorf("nonce_file = %q, want default", *cfg.Store.NonceFile)
+	}
+	if cfg.RateLimit.Rules["login"].Limit != 3 {
+		t.Errorf("login rule overwritten: %+v", cfg.RateLimit.Rules["login"])
+	}
+	for group := range defaultRateLimitRules() {
+		if _, ok := cfg.RateLimit.Rules[group]; !ok {
+			t.Errorf("missing default rate limit group %s", group)
+		}
+	}
+}
+
+func TestApplyDefaultsExplicitOptOut(t *testing.T) {
+	cfg := loadYAML(t, `
+security:
+  key_grace_period: 0
+store:
+  nonce_file: ""
+rate_limit:
+  rules:
+    handshake: {key: ip, limit: 0, window: 60}
+`)
+	if *cfg.Security.KeyGracePeriod != 0 {
+		t.Errorf("key_grace_period = %d, want 0", *cfg.Security.KeyGracePeriod)
+	}
+	if *cfg.Store.NonceFile != "" {
+		t.Errorf("nonce_file = %q, want empty", *cfg.Store.NonceFile)
+	}
+	if cfg.RateLimit.Rules["handshake"].Limit != 0 {
+		t.Errorf("handshake rule = %+v, want disabled", cfg.RateLimit.Rules["handshake"])
+	}
+}
@@ -0,0 +1,242 @@
+// Package jsonschema JSON Schema 的精简实现，用于校验客户端写入的专属信息
+//
+// 支持的关键字: type、properties、required、additionalProperties、items、enum、
+// minimum、maximum、exclusiveMinimum、exclusiveMaximum、minLength、maxLength、
+// pattern、minItems、maxItems。其余关键字（如 $ref、anyOf）会被忽略。
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+type Schema struct {
+	Type                 typeList           `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// typeList type 关键字可以是字符串或字符串数组
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = typeList{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("type 必须是字符串或字符串数组")
+	}
+	*t = multiple
+	return nil
+}
+
+// additional additionalProperties 可以是布尔值或子模式，null 与未设置相同
+type additional struct {
+	allowed bool
+	schema  *Schema
+}
+
+func (a *additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// Compile 解析模式并预编译正则
+func Compile(schema string) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		return nil, fmt.Errorf("无效的JSON Schema: %v", err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("无效的 pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	for name, child := range s.Properties {
+		// "a": null 解析为空指针，校验时会引用空模式
+		if child == nil {
+			return fmt.Errorf("properties.%s 必须是对象", name)
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil {
+		return s.AdditionalProperties.schema.compile()
+	}
+	return nil
+}
+
+// Validate 校验JSON文本，返回第一个不符合的位置
+func (s *Schema) Validate(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.New("不是合法的JSON")
+	}
+	return s.validate("$", value)
+}
+
+func (s *Schema) validate(path string, value interface{}) error {
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return matchType(t, value) }) {
+		return fmt.Errorf("%s: 类型应为 %s", path, strings.Join(s.Type, "/"))
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e interface{}) bool { return reflect.DeepEqual(e, value) }) {
+		return fmt.Errorf("%s: 不在允许的取值范围内", path)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return s.validateNumber(path, v)
+	case string:
+		return s.validateString(path, v)
+	case []interface{}:
+		return s.validateArray(path, v)
+	case map[string]interface{}:
+		return s.validateObject(path, v)
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(path string, v float64) error {
+	if s.Minimum != nil && v < *s.Minimum {
+		return fmt.Errorf("%s: 不能小于 %v", path, *s.Minimum)
+	}
+	if s.Maximum != nil && v > *s.Maximum {
+		return fmt.Errorf("%s: 不能大于 %v", path, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+		return fmt.Errorf("%s: 必须大于 %v", path, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+		return fmt.Errorf("%s: 必须小于 %v", path, *s.ExclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *Schema) validateString(path string, v string) error {
+	length := utf8.RuneCountInString(v)
+	if s.MinLength != nil && length < *s.MinLength {
+		return fmt.Errorf("%s: 长度不能小于 %d", path, *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return fmt.Errorf("%s: 长度不能大于 %d", path, *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		return fmt.Errorf("%s: 格式不匹配", path)
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(path string, v []interface{}) error {
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		return fmt.Errorf("%s: 元素数不能少于 %d", path, *s.MinItems)
+	}
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		return fmt.Errorf("%s: 元素数不能多于 %d", path, *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, v map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return fmt.Errorf("%s: 缺少必填字段 %s", path, name)
+		}
+	}
+	for name, item := range v {
+		childPath := path + "." + name
+		if child, ok := s.Properties[name]; ok {
+			if err := child.validate(childPath, item); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.allowed {
+			return fmt.Errorf("%s: 不允许的字段", childPath)
+		}
+		if s.AdditionalProperties.schema != nil {
+			if err := s.AdditionalProperties.schema.validate(childPath, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchType(t string, value interface{}) bool {
+	switch t {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return false
+}
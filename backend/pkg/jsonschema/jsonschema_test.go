@@ -0,0 +1,131 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+type validateCase struct {
+	data string
+	ok   bool
+}
+
+func runCases(t *testing.T, schema string, cases []validateCase) {
+	t.Helper()
+	s, err := Compile(schema)
+	if err != nil {
+		t.Fatalf("Compile(%s): %v", schema, err)
+	}
+	for _, c := range cases {
+		err := s.Validate([]byte(c.data))
+		if (err == nil) != c.ok {
+			t.Errorf("schema %s, data %s: err = %v, want ok = %v", schema, c.data, err, c.ok)
+		}
+	}
+}
+
+func TestCompileRejectsInvalidSchema(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`{"type": 1}`,
+		`{"pattern": "("}`,
+		`{"properties": {"a": null}}`,
+		`{"items": {"properties": {"a": null}}}`,
+		`{"properties": {"a": {"properties": {"b": null}}}}`,
+		`{"additionalProperties": {"properties": {"a": null}}}`,
+		`{"additionalProperties": 1}`,
+	} {
+		if _, err := Compile(schema); err == nil {
+			t.Errorf("Compile(%s) succeeded, want error", schema)
+		}
+	}
+}
+
+func TestValidateRejectsInvalidJSON(t *testing.T) {
+	s, err := Compile(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte(`{`)); err == nil {
+		t.Fatal("invalid JSON accepted")
+	}
+}
+
+func TestType(t *testing.T) {
+	runCases(t, `{"type": "string"}`, []validateCase{{`"a"`, true}, {`1`, false}, {`null`, false}})
+	runCases(t, `{"type": ["number", "null"]}`, []validateCase{{`1.5`, true}, {`null`, true}, {`"1"`, false}})
+	runCases(t, `{"type": "integer"}`, []validateCase{{`2`, true}, {`2.0`, true}, {`2.5`, false}})
+	runCases(t, `{"type": "boolean"}`, []validateCase{{`true`, true}, {`0`, false}})
+	runCases(t, `{"type": "array"}`, []validateCase{{`[]`, true}, {`{}`, false}})
+	runCases(t, `{"type": "object"}`, []validateCase{{`{}`, true}, {`[]`, false}})
+	runCases(t, `{"type": "unknown"}`, []validateCase{{`1`, false}})
+}
+
+func TestEnum(t *testing.T) {
+	runCases(t, `{"enum": ["a", 1, null, {"k": [1]}]}`, []validateCase{
+		{`"a"`, true}, {`1`, true}, {`null`, true}, {`{"k": [1]}`, true},
+		{`"b"`, false}, {`{"k": [2]}`, false},
+	})
+}
+
+func TestNumberKeywords(t *testing.T) {
+	runCases(t, `{"minimum": 1, "maximum": 3}`, []validateCase{{`1`, true}, {`3`, true}, {`0.9`, false}, {`3.1`, false}})
+	runCases(t, `{"exclusiveMinimum": 1, "exclusiveMaximum": 3}`, []validateCase{{`2`, true}, {`1`, false}, {`3`, false}})
+	// 数值关键字不约束其他类型
+	runCases(t, `{"minimum": 1}`, []validateCase{{`"0"`, true}})
+}
+
+func TestStringKeywords(t *testing.T) {
+	// 长度按字符计算
+	runCases(t, `{"minLength": 2, "maxLength": 3}`, []validateCase{{`"ab"`, true}, {`"中文字"`, true}, {`"a"`, false}, {`"abcd"`, false}})
+	runCases(t, `{"pattern": "^[a-z]+$"}`, []validateCase{{`"abc"`, true}, {`"Abc"`, false}})
+}
+
+func TestArrayKeywords(t *testing.T) {
+	runCases(t, `{"minItems": 1, "maxItems": 2, "items": {"type": "integer"}}`, []validateCase{
+		{`[1]`, true}, {`[1, 2]`, true}, {`[]`, false}, {`[1, 2, 3]`, false}, {`[1, "2"]`, false},
+	})
+}
+
+func TestObjectKeywords(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"additionalProperties": false
+	}`
+	runCases(t, schema, []validateCase{
+		{`{"name": "a"}`, true},
+		{`{"name": "a", "tags": ["x"]}`, true},
+		{`{}`, false},
+		{`{"name": 1}`, false},
+		{`{"name": "a", "tags": [1]}`, false},
+		{`{"name": "a", "extra": 1}`, false},
+	})
+
+	runCases(t, `{"properties": {"a": {}}, "additionalProperties": {"type": "number"}}`, []validateCase{
+		{`{"a": "x", "b": 1}`, true}, {`{"b": "x"}`, false},
+	})
+	runCases(t, `{"additionalProperties": true}`, []validateCase{{`{"b": "x"}`, true}})
+	// null 与未设置相同，不限制额外字段
+	runCases(t, `{"additionalProperties": null}`, []validateCase{{`{"b": "x"}`, true}})
+	runCases(t, `{"properties": {"a": {"type": "string"}}}`, []validateCase{{`{"b": 1}`, true}})
+}
+
+func TestErrorPath(t *testing.T) {
+	s, err := Compile(`{"properties": {"list": {"items": {"properties": {"n": {"type": "number"}}}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Validate([]byte(`{"list": [{"n": 1}, {"n": "x"}]}`))
+	if err == nil || !strings.HasPrefix(err.Error(), "$.list[1].n:") {
+		t.Fatalf("err = %v, want path $.list[1].n", err)
+	}
+}
+
+func TestIgnoredKeywords(t *testing.T) {
+	runCases(t, `{"anyOf": [{"type": "string"}], "$ref": "#/x"}`, []validateCase{{`1`, true}})
+}
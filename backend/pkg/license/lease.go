@@ -0,0 +1,80 @@
+package license
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const LeasePrefix = "NKT1"
+
+var ErrLeaseEnded = errors.New("租约已结束")
+
+// Lease 登录和心跳返回的租约，服务端或网络短暂不可用时客户端据此继续运行
+type Lease struct {
+	Version     int    `json:"v"`
+	ProjectUUID string `json:"project_uuid"`
+	CardID      uint   `json:"card_id,omitempty"` // 免费模式为 0
+	HWID        string `json:"hwid,omitempty"`
+	IssuedAt    int64  `json:"iat"`
+	ExpireAt    int64  `json:"exp"`         // 正常有效期，到期前应完成下一次心跳
+	GraceUntil  int64  `json:"grace_until"` // 无法联网时最晚可运行到的时间
+}
+
+// SignLease 生成租约内容
+func SignLease(l *Lease, privateKey ed25519.PrivateKey) (string, error) {
+	if l.Version == 0 {
+		l.Version = Version
+	}
+	payload, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return encode(LeasePrefix, payload, privateKey), nil
+}
+
+// VerifyLease 校验租约签名，不检查有效期和设备码
+func VerifyLease(data string, publicKey ed25519.PublicKey) (*Lease, error) {
+	payload, signature, err := split(data, LeasePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var l Lease
+	if err := json.Unmarshal(payload, &l); err != nil || l.Version != Version {
+		return nil, ErrMalformed
+	}
+	if !verifySignature(publicKey, LeasePrefix, payload, signature) {
+		return nil, ErrInvalidSignature
+	}
+	return &l, nil
+}
+
+// VerifyLeaseBase64 同 VerifyLease，公钥为Base64编码
+func VerifyLeaseBase64(data, publicKey string) (*Lease, error) {
+	return VerifyLease(data, decodePublicKey(publicKey))
+}
+
+// Check 检查项目、设备码，且当前时间在宽限期结束之前
+func (l *Lease) Check(projectUUID, hwid string, now time.Time) error {
+	if projectUUID != "" && l.ProjectUUID != projectUUID {
+		return ErrProjectMismatch
+	}
+	if l.HWID != "" && l.HWID != hwid {
+		return ErrHWIDMismatch
+	}
+	if now.Unix() >= l.GraceUntil {
+		return ErrLeaseEnded
+	}
+	return nil
+}
+
+// InGrace 已过正常有效期、处于宽限期内，客户端应尽快重新心跳
+func (l *Lease) InGrace(now time.Time) bool {
+	return now.Unix() >= l.ExpireAt && now.Unix() < l.GraceUntil
+}
+
+func (l *Lease) GraceTime() time.Time {
+	return time.Unix(l.GraceUntil, 0)
+}
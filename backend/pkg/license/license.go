@@ -0,0 +1,173 @@
+// Package license 离线授权文件和登录租约的生成与校验
+//
+// 授权文件格式: NKL1.<Base64URL(载荷JSON)>.<Base64URL(Ed25519签名)>，租约前缀为 NKT1。
+// 签名对象为 "<前缀>." 加解码后的载荷JSON原文，前缀参与签名，租约不能改前缀冒充授权文件，
+// 签名密钥与项目响应签名密钥相同。
+// 本包只依赖标准库，客户端可直接引入，内置项目签名公钥后离线校验。
+package license
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	Prefix  = "NKL1"
+	Version = 2 // 版本 1 只对载荷签名，已不再接受
+)
+
+var (
+	ErrMalformed        = errors.New("授权文件格式错误")
+	ErrInvalidSignature = errors.New("授权文件签名无效")
+	ErrExpired          = errors.New("授权已过期")
+	ErrNotYetValid      = errors.New("授权尚未生效")
+	ErrHWIDMismatch     = errors.New("授权与本机设备码不匹配")
+	ErrProjectMismatch  = errors.New("授权不属于当前项目")
+)
+
+var encoding = base64.RawURLEncoding
+
+// License 离线授权载荷
+type License struct {
+	Version     int      `json:"v"`
+	ID          string   `json:"lid"`
+	ProjectUUID string   `json:"project_uuid"`
+	CardID      uint     `json:"card_id"`
+	HWID        string   `json:"hwid"`
+	IssuedAt    int64    `json:"iat"`
+	ExpireAt    int64    `json:"exp"` // 0 表示永久
+	Features    []string `json:"features,omitempty"`
+	CustomData  string   `json:"custom_data,omitempty"`
+}
+
+// Sign 生成授权文件内容
+func Sign(l *License, privateKey ed25519.PrivateKey) (string, error) {
+	if l.Version == 0 {
+		l.Version = Version
+	}
+	payload, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	return encode(Prefix, payload, privateKey), nil
+}
+
+// Parse 解析授权文件但不校验签名，仅用于展示
+func Parse(data string) (*License, error) {
+	l, _, _, err := parse(data)
+	return l, err
+}
+
+// Verify 校验签名并返回授权内容，不检查有效期和设备码
+func Verify(data string, publicKey ed25519.PublicKey) (*License, error) {
+	l, payload, signature, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(publicKey, Prefix, payload, signature) {
+		return nil, ErrInvalidSignature
+	}
+	return l, nil
+}
+
+// VerifyBase64 同 Verify，公钥为Base64编码（管理后台显示的 signing_public_key）
+func VerifyBase64(data, publicKey string) (*License, error) {
+	return Verify(data, decodePublicKey(publicKey))
+}
+
+// VerifyFile 读取授权文件并校验签名
+func VerifyFile(path string, publicKey ed25519.PublicKey) (*License, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(string(data), publicKey)
+}
+
+// Check 检查项目、设备码和有效期，projectUUID 为空时不检查项目
+func (l *License) Check(projectUUID, hwid string, now time.Time) error {
+	if projectUUID != "" && l.ProjectUUID != projectUUID {
+		return ErrProjectMismatch
+	}
+	if l.HWID != "" && l.HWID != hwid {
+		return ErrHWIDMismatch
+	}
+	if l.IssuedAt > 0 && now.Unix() < l.IssuedAt {
+		return ErrNotYetValid
+	}
+	if l.ExpireAt > 0 && now.Unix() >= l.ExpireAt {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (l *License) HasFeature(name string) bool {
+	return slices.Contains(l.Features, name)
+}
+
+// ExpireTime 过期时间，永久授权返回零值
+func (l *License) ExpireTime() time.Time {
+	if l.ExpireAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(l.ExpireAt, 0)
+}
+
+func parse(data string) (*License, []byte, []byte, error) {
+	payload, signature, err := split(data, Prefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var l License
+	if err := json.Unmarshal(payload, &l); err != nil || l.Version != Version {
+		return nil, nil, nil, ErrMalformed
+	}
+	return &l, payload, signature, nil
+}
+
+// split 拆分 <前缀>.<载荷>.<签名> 格式的数据
+func split(data, prefix string) ([]byte, []byte, error) {
+	parts := strings.Split(strings.TrimSpace(data), ".")
+	if len(parts) != 3 || parts[0] != prefix {
+		return nil, nil, ErrMalformed
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, nil, ErrMalformed
+	}
+	return payload, signature, nil
+}
+
+func encode(prefix string, payload []byte, privateKey ed25519.PrivateKey) string {
+	signature := ed25519.Sign(privateKey, signingInput(prefix, payload))
+	return prefix + "." + encoding.EncodeToString(payload) + "." + encoding.EncodeToString(signature)
+}
+
+// signingInput 签名对象，前缀区分授权文件和租约
+func signingInput(prefix string, payload []byte) []byte {
+	return append([]byte(prefix+"."), payload...)
+}
+
+func verifySignature(publicKey ed25519.PublicKey, prefix string, payload, signature []byte) bool {
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, signingInput(prefix, payload), signature)
+}
+
+func decodePublicKey(publicKey string) ed25519.PublicKey {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil
+	}
+	return ed25519.PublicKey(key)
+}
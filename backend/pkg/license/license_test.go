@@ -0,0 +1,74 @@
+package license
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+// swapPrefix 只替换前缀，载荷和签名保持不变
+func swapPrefix(data, prefix string) string {
+	return prefix + data[strings.Index(data, "."):]
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	now := time.Now()
+
+	content, err := Sign(&License{ID: "lid", ProjectUUID: "p", CardID: 1, HWID: "h", IssuedAt: now.Unix(), ExpireAt: now.Add(time.Hour).Unix()}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Verify(content, publicKey)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := l.Check("p", "h", now); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	leaseContent, err := SignLease(&Lease{ProjectUUID: "p", IssuedAt: now.Unix(), ExpireAt: now.Add(time.Minute).Unix(), GraceUntil: now.Add(time.Hour).Unix()}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyLease(leaseContent, publicKey); err != nil {
+		t.Fatalf("VerifyLease: %v", err)
+	}
+}
+
+// 租约不带设备码时，改前缀冒充离线授权可在任意设备上使用，必须被拒绝
+func TestLeaseRejectedAsLicense(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	now := time.Now()
+
+	lease, err := SignLease(&Lease{ProjectUUID: "p", IssuedAt: now.Unix(), ExpireAt: now.Add(time.Hour).Unix(), GraceUntil: now.Add(2 * time.Hour).Unix()}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(swapPrefix(lease, Prefix), publicKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify(lease) err = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestLicenseRejectedAsLease(t *testing.T) {
+	publicKey, privateKey := newKey(t)
+	now := time.Now()
+
+	content, err := Sign(&License{ID: "lid", ProjectUUID: "p", IssuedAt: now.Unix(), ExpireAt: now.Add(time.Hour).Unix()}, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyLease(swapPrefix(content, LeasePrefix), publicKey); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("VerifyLease(license) err = %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,67 @@
+// Package textdiff 按行比较两段文本，用于展示云变量版本差异
+package textdiff
+
+import "strings"
+
+const (
+	OpEqual  = "="
+	OpDelete = "-"
+	OpInsert = "+"
+)
+
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines 基于最长公共子序列输出逐行差异，删除行排在插入行之前
+func Lines(a, b string) []Line {
+	x := split(a)
+	y := split(b)
+
+	// lcs[i][j] 为 x[i:] 与 y[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]Line, 0, len(x)+len(y))
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{OpEqual, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{OpDelete, x[i]})
+			i++
+		default:
+			lines = append(lines, Line{OpInsert, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, Line{OpDelete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, Line{OpInsert, y[j]})
+	}
+	return lines
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -15,8 +15,9 @@ type Response struct {
 }
 
 type EncryptedResponse struct {
-	Nonce string `json:"nonce"`
-	Data  string `json:"data"`
+	Nonce     string `json:"nonce"`
+	Data      string `json:"data"`
+	Signature string `json:"signature,omitempty"` // 对 data 解密后的 InternalResponse 原文的 Ed25519 签名
 }
 
 type InternalResponse struct {
@@ -100,15 +101,21 @@ func EncryptedSuccess(c *gin.Context, data interface{}) {
 	}
 
 	c.JSON(200, EncryptedResponse{
-		Nonce: nonceStr,
-		Data:  encryptedData,
+		Nonce:     nonceStr,
+		Data:      encryptedData,
+		Signature: signResponse(c, jsonData),
 	})
 }
 
 func EncryptedError(c *gin.Context, code int, message string) {
+	EncryptedErrorWithData(c, code, message, nil)
+}
+
+func EncryptedErrorWithData(c *gin.Context, code int, message string, data interface{}) {
 	resp := Response{
 		Code:    code,
 		Message: message,
+		Data:    data,
 	}
 
 	// 从上下文获取nonce
@@ -148,7 +155,21 @@ func EncryptedError(c *gin.Context, code int, message string) {
 	}
 
 	c.JSON(200, EncryptedResponse{
-		Nonce: nonceStr,
-		Data:  encryptedData,
+		Nonce:     nonceStr,
+		Data:      encryptedData,
+		Signature: signResponse(c, jsonData),
 	})
 }
+
+// signResponse 使用项目签名私钥对内层响应签名，客户端用内置公钥验证，防止伪造服务端
+func signResponse(c *gin.Context, data []byte) string {
+	signerVal, exists := c.Get("signer")
+	if !exists {
+		return ""
+	}
+	signer, ok := signerVal.(*crypto.Signer)
+	if !ok {
+		return ""
+	}
+	return signer.Sign(data)
+}
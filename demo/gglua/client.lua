@@ -75,6 +75,7 @@ function CardLogin:login(card_key, hwid, ip)
     local fullRequest = {
         timestamp = timestamp,
         nonce = nonce,
+        project_uuid = self.project_uuid,
         data = encryptedData
     }
     
@@ -8,6 +8,7 @@ use std::time::{SystemTime, UNIX_EPOCH};
 pub struct EncryptedRequest {
     timestamp: u64,
     nonce: String,
+    project_uuid: String,
     data: String,
 }
 
@@ -189,6 +190,7 @@ impl NextKeyClient {
         let req_body = EncryptedRequest {
             timestamp: request_timestamp,
             nonce: request_nonce.clone(),
+            project_uuid: self.project_uuid.clone(),
             data: encrypted_data,
         };
 
@@ -14,6 +14,11 @@ import secrets
 import threading
 from datetime import datetime
 from Crypto.Cipher import AES, ARC4, ChaCha20_Poly1305
+from cryptography.hazmat.primitives import hashes
+from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PublicKey
+from cryptography.hazmat.primitives.asymmetric.x25519 import X25519PrivateKey, X25519PublicKey
+from cryptography.exceptions import InvalidSignature
+from cryptography.hazmat.primitives.kdf.hkdf import HKDF
 import requests
 import os
 import yaml
@@ -22,12 +27,23 @@ import yaml
 class NextKeyClient:
     """NextKey API 客户端"""
     
-    def __init__(self, server_url, project_uuid, aes_key, encryption_scheme="aes-256-gcm"):
+    def __init__(self, server_url, project_uuid, aes_key, encryption_scheme="aes-256-gcm", signing_public_key=""):
         self.server_url = server_url.rstrip('/')
         self.project_uuid = project_uuid
         self.encryption_scheme = encryption_scheme.lower()
         self.aes_key = self._prepare_key(aes_key)
+        # 响应签名公钥，配置后校验每个加密响应，防止被重定向到伪造的服务端
+        self.signing_public_key = None
+        if signing_public_key:
+            self.signing_public_key = Ed25519PublicKey.from_public_bytes(base64.b64decode(signing_public_key))
         self.token = None
+        # 最近一次登录/心跳返回的租约载荷，断网时在 grace_until 之前可继续运行
+        self.lease = None
+        # 密钥交换方案的会话状态
+        self.session_id = None
+        self.session_expire = 0
+        self.send_key = None
+        self.recv_key = None
         self.session = requests.Session()
         self.session.headers.update({'Content-Type': 'application/json'})
     
@@ -80,6 +96,13 @@ class NextKeyClient:
             except ValueError:
                 return key_str.encode('utf-8')
         
+        elif self.encryption_scheme == "x25519-chacha20-poly1305":
+            # 密钥交换方案只内置服务端公钥（Base64，32字节）
+            key_bytes = base64.b64decode(key_str)
+            if len(key_bytes) != 32:
+                raise ValueError(f"服务端公钥长度错误，应为32字节，实际: {len(key_bytes)}")
+            return key_bytes
+        
         elif self.encryption_scheme == "custom-base64":
             # 自定义Base64需要64字符的映射表
             if len(key_str) != 64:
@@ -101,6 +124,8 @@ class NextKeyClient:
             return self._encrypt_xor(plaintext)
         elif self.encryption_scheme == "custom-base64":
             return self._encrypt_custom_base64(plaintext)
+        elif self.encryption_scheme == "x25519-chacha20-poly1305":
+            return self._encrypt_session(plaintext)
         else:
             raise ValueError(f"不支持的加密方案: {self.encryption_scheme}")
     
@@ -116,6 +141,8 @@ class NextKeyClient:
             return self._decrypt_xor(ciphertext)
         elif self.encryption_scheme == "custom-base64":
             return self._decrypt_custom_base64(ciphertext)
+        elif self.encryption_scheme == "x25519-chacha20-poly1305":
+            return self._decrypt_session(ciphertext)
         else:
             raise ValueError(f"不支持的加密方案: {self.encryption_scheme}")
     
@@ -155,6 +182,57 @@ class NextKeyClient:
         cipher = ChaCha20_Poly1305.new(key=self.aes_key, nonce=nonce)
         return cipher.decrypt_and_verify(ciphertext, tag).decode()
     
+    def handshake(self):
+        """X25519握手，派生会话密钥（服务端公钥来自本地配置，不信任服务端返回值）"""
+        private_key = X25519PrivateKey.generate()
+        client_pub = private_key.public_key().public_bytes_raw()
+        
+        response = self.session.post(f"{self.server_url}/api/crypto/handshake", json={
+            "project_uuid": self.project_uuid,
+            "client_public_key": base64.b64encode(client_pub).decode()
+        })
+        result = response.json()
+        if result.get("code") != 0:
+            raise ValueError(f"握手失败: {result.get('message')}")
+        data = result["data"]
+        
+        shared = private_key.exchange(X25519PublicKey.from_public_bytes(self.aes_key))
+        salt = client_pub + self.aes_key
+        
+        def derive(info):
+            return HKDF(algorithm=hashes.SHA256(), length=32, salt=salt, info=info).derive(shared)
+        
+        self.send_key = derive(b"nextkey session v1 c2s")
+        self.recv_key = derive(b"nextkey session v1 s2c")
+        
+        # 校验服务端确实持有与内置公钥对应的私钥
+        if self._decrypt_session(data["confirm"]) != data["session_id"]:
+            self.send_key = self.recv_key = None
+            raise ValueError("握手确认失败，服务端公钥与配置不一致")
+        
+        self.session_id = data["session_id"]
+        # 不依赖本地与服务端时钟一致，每小时重新握手，短于服务端会话有效期即可
+        self.session_expire = time.time() + 3600
+        return data
+    
+    def _ensure_session(self):
+        """会话不存在或即将过期时重新握手"""
+        if self.session_id is None or time.time() >= self.session_expire:
+            self.handshake()
+    
+    def _encrypt_session(self, plaintext):
+        """会话加密（客户端到服务端密钥）"""
+        nonce = secrets.token_bytes(12)
+        cipher = ChaCha20_Poly1305.new(key=self.send_key, nonce=nonce)
+        ciphertext, tag = cipher.encrypt_and_digest(plaintext.encode())
+        return base64.b64encode(nonce + ciphertext + tag).decode()
+    
+    def _decrypt_session(self, ciphertext):
+        """会话解密（服务端到客户端密钥）"""
+        data = base64.b64decode(ciphertext)
+        cipher = ChaCha20_Poly1305.new(key=self.recv_key, nonce=data[:12])
+        return cipher.decrypt_and_verify(data[12:-16], data[-16:]).decode()
+    
     def _encrypt_rc4(self, plaintext):
         """RC4加密"""
         cipher = ARC4.new(self.aes_key)
@@ -235,14 +313,20 @@ class NextKeyClient:
             "data": data
         }
         
+        if self.encryption_scheme == "x25519-chacha20-poly1305":
+            self._ensure_session()
+        
         json_data = json.dumps(internal_data)
         encrypted_data = self.encrypt(json_data)
         
         req_body = {
             "timestamp": request_timestamp,
             "nonce": request_nonce,
+            "project_uuid": self.project_uuid,
             "data": encrypted_data
         }
+        if self.session_id:
+            req_body["session_id"] = self.session_id
         
         url = f"{self.server_url}{endpoint}"
         
@@ -284,6 +368,17 @@ class NextKeyClient:
         
         # 解密响应数据
         decrypted = self.decrypt(resp_json["data"])
+        
+        # 签名覆盖解密后的原文，必须在解析JSON之前按原始字节校验
+        if self.signing_public_key is not None:
+            signature = resp_json.get("signature")
+            if not signature:
+                raise ValueError("响应缺少签名，可能连接到了伪造的服务端")
+            try:
+                self.signing_public_key.verify(base64.b64decode(signature), decrypted.encode())
+            except InvalidSignature:
+                raise ValueError("响应签名验证失败，可能连接到了伪造的服务端")
+        
         internal_response = json.loads(decrypted)
         
         # 验证内层响应nonce（双重验证）
@@ -303,7 +398,7 @@ class NextKeyClient:
         # 返回解密后的结果、请求体、原始加密响应和完整的内层响应（用于调试）
         return result, req_body, resp_json, internal_response
     
-    def login(self, card_key, hwid="", ip=""):
+    def login(self, card_key, hwid="", ip="", client_version=""):
         """登录"""
         login_data = {
             "project_uuid": self.project_uuid,
@@ -313,25 +408,57 @@ class NextKeyClient:
             login_data["hwid"] = hwid
         if ip:
             login_data["ip"] = ip
+        if client_version:
+            login_data["client_version"] = client_version
         
         result, request, encrypted_response, internal_response = self.make_encrypted_request("/api/auth/login", login_data)
         
         if result.get("code") == 0:
             self.token = result["data"]["token"]
             self.session.headers.update({"Authorization": f"Bearer {self.token}"})
+            self._store_lease(result["data"].get("lease"))
         
         return result, request, encrypted_response, internal_response
     
     def heartbeat(self):
         """心跳"""
         result, _, _, _ = self.make_encrypted_request("/api/heartbeat", {})
+        if result.get("code") == 0:
+            self._store_lease(result["data"].get("lease"))
         return result
     
+    def _store_lease(self, lease):
+        """解析租约，配置了签名公钥时校验签名（NKT1.<载荷>.<签名>，Base64URL无填充，签名对象为 "NKT1." + 载荷原文）"""
+        if not lease:
+            return
+        parts = lease.split(".")
+        if len(parts) != 3 or parts[0] != "NKT1":
+            raise ValueError("租约格式错误")
+        decode = lambda s: base64.urlsafe_b64decode(s + "=" * (-len(s) % 4))
+        payload = decode(parts[1])
+        if self.signing_public_key is not None:
+            try:
+                self.signing_public_key.verify(decode(parts[2]), b"NKT1." + payload)
+            except InvalidSignature:
+                raise ValueError("租约签名验证失败")
+        self.lease = json.loads(payload)
+    
     def get_cloud_var(self, key):
         """获取云变量"""
         result, _, _, _ = self.make_encrypted_request(f"/api/cloud-var/{key}", {}, method="GET")
         return result
     
+    def get_cloud_vars(self, keys=None, etag=""):
+        """批量获取云变量，keys 为空时获取全部可见变量"""
+        data = {"keys": keys or [], "etag": etag}
+        result, _, _, _ = self.make_encrypted_request("/api/cloud-vars", data)
+        return result
+    
+    def get_cloud_var_changes(self, since):
+        """获取修订号 since 之后变化的云变量"""
+        result, _, _, _ = self.make_encrypted_request("/api/cloud-vars/changes", {"since": since})
+        return result
+    
     def update_custom_data(self, custom_data):
         """更新专属信息 - 支持任意字符串"""
         # custom_data 可以是任意字符串，不限于 JSON
@@ -339,6 +466,16 @@ class NextKeyClient:
         result, _, _, _ = self.make_encrypted_request("/api/card/custom-data", data)
         return result
     
+    def get_announcements(self, unread_only=False):
+        """获取当前生效的公告和消息"""
+        result, _, _, _ = self.make_encrypted_request("/api/announcements", {"unread_only": unread_only})
+        return result
+    
+    def ack_announcements(self, ids):
+        """确认公告已读"""
+        result, _, _, _ = self.make_encrypted_request("/api/announcements/ack", {"ids": ids})
+        return result
+    
     def get_project_info(self):
         """获取项目信息"""
         result, _, _, _ = self.make_encrypted_request("/api/project/info", {}, method="GET")
@@ -419,6 +556,7 @@ class NextKeyGUI:
         scheme_combo['values'] = (
             'aes-256-gcm (推荐-安全)', 
             'chacha20-poly1305 (安全-高性能)',
+            'x25519-chacha20-poly1305 (安全-密钥交换)',
             'rc4 (已弃用-不安全)', 
             'xor (已弃用-不安全)', 
             'custom-base64 (不安全)'
@@ -432,9 +570,15 @@ class NextKeyGUI:
         self.key_entry = ttk.Entry(frame, textvariable=self.aes_key_var, width=50, show="*")
         self.key_entry.grid(row=3, column=1, pady=5, padx=5)
         
+        # 签名公钥
+        ttk.Label(frame, text="签名公钥:").grid(row=4, column=0, sticky=tk.W, pady=5)
+        self.signing_public_key_var = tk.StringVar()
+        ttk.Entry(frame, textvariable=self.signing_public_key_var, width=50).grid(row=4, column=1, pady=5, padx=5)
+        ttk.Label(frame, text="(可选，填写后校验响应签名)").grid(row=4, column=2, sticky=tk.W, padx=5)
+        
         # 按钮框
         btn_frame = ttk.Frame(frame)
-        btn_frame.grid(row=4, column=0, columnspan=2, pady=10)
+        btn_frame.grid(row=5, column=0, columnspan=2, pady=10)
         
         ttk.Button(btn_frame, text="从config.yaml读取", command=self.load_from_yaml).pack(side=tk.LEFT, padx=5)
         ttk.Button(btn_frame, text="保存配置", command=self.save_config).pack(side=tk.LEFT, padx=5)
@@ -630,6 +774,7 @@ class NextKeyGUI:
         hints = {
             'aes-256-gcm': "32字节密钥 (64字符hex或base64)",
             'chacha20-poly1305': "32字节密钥 (64字符hex或base64)",
+            'x25519-chacha20-poly1305': "服务端公钥 (管理后台项目详情中的 public_key，base64)",
             'rc4': "hex编码的密钥或任意字符串",
             'xor': "hex编码的密钥或任意字符串",
             'custom-base64': "64个不重复字符的映射表"
@@ -667,7 +812,8 @@ class NextKeyGUI:
             "server_url": self.server_url_var.get(),
             "project_uuid": self.project_uuid_var.get(),
             "aes_key": self.aes_key_var.get(),
-            "encryption_scheme": scheme
+            "encryption_scheme": scheme,
+            "signing_public_key": self.signing_public_key_var.get()
         }
         
         try:
@@ -689,12 +835,14 @@ class NextKeyGUI:
                 self.server_url_var.set(config.get("server_url", "http://localhost:8080"))
                 self.project_uuid_var.set(config.get("project_uuid", ""))
                 self.aes_key_var.set(config.get("aes_key", ""))
+                self.signing_public_key_var.set(config.get("signing_public_key", ""))
                 
                 # 加载加密方案
                 scheme = config.get("encryption_scheme", "aes-256-gcm")
                 scheme_map = {
                     'aes-256-gcm': 'aes-256-gcm (推荐-安全)',
                     'chacha20-poly1305': 'chacha20-poly1305 (安全-高性能)',
+                    'x25519-chacha20-poly1305': 'x25519-chacha20-poly1305 (安全-密钥交换)',
                     'rc4': 'rc4 (已弃用-不安全)',
                     'xor': 'xor (已弃用-不安全)',
                     'custom-base64': 'custom-base64 (不安全)'
@@ -715,7 +863,8 @@ class NextKeyGUI:
                 self.server_url_var.get(),
                 self.project_uuid_var.get(),
                 self.aes_key_var.get(),
-                scheme
+                scheme,
+                self.signing_public_key_var.get()
             )
             
             url = f"{self.server_url_var.get()}/api/heartbeat"
@@ -747,7 +896,8 @@ class NextKeyGUI:
                     self.server_url_var.get(),
                     self.project_uuid_var.get(),
                     self.aes_key_var.get(),
-                    scheme
+                    scheme,
+                    self.signing_public_key_var.get()
                 )
             except Exception as e:
                 messagebox.showerror("错误", f"初始化客户端失败: {e}")
@@ -792,6 +942,7 @@ class NextKeyGUI:
             
             if result.get("code") == 0:
                 self.log("登录成功", "success")
+                self.show_announcements(result["data"].get("announcements"))
                 messagebox.showinfo("成功", "登录成功！")
             else:
                 self.log(f"登录失败: {result.get('message')}", "error")
@@ -812,6 +963,10 @@ class NextKeyGUI:
             
             if result.get("code") == 0:
                 self.log("心跳成功", "success")
+                if self.client.lease:
+                    grace_until = datetime.fromtimestamp(self.client.lease["grace_until"])
+                    self.log(f"租约宽限期至 {grace_until:%Y-%m-%d %H:%M:%S}", "info")
+                self.show_announcements(result["data"].get("announcements"))
             else:
                 self.log(f"心跳失败: {result.get('message')}", "error")
                 messagebox.showerror("失败", f"心跳失败: {result.get('message')}")
@@ -820,6 +975,19 @@ class NextKeyGUI:
             self.log(f"心跳异常: {e}", "error")
             messagebox.showerror("错误", f"心跳异常: {e}")
     
+    def show_announcements(self, announcements):
+        """记录未读公告并确认已读"""
+        if not announcements:
+            return
+        for item in announcements:
+            target = "消息" if item.get("card_id") else "公告"
+            self.log(f"[{target}] {item['title']}: {item['content']}", "info")
+        result = self.client.ack_announcements([item["id"] for item in announcements])
+        if result.get("code") == 0:
+            self.log(f"已确认 {result['data']['acked']} 条公告", "success")
+        else:
+            self.log(f"确认公告失败: {result.get('message')}", "error")
+    
     def start_auto_heartbeat(self):
         """开始自动心跳"""
         if not self.client or not self.client.token:
@@ -849,6 +1017,7 @@ class NextKeyGUI:
                     result = self.client.heartbeat()
                     if result.get("code") == 0:
                         self.log("自动心跳成功", "success")
+                        self.show_announcements(result["data"].get("announcements"))
                     else:
                         self.log(f"自动心跳失败: {result.get('message')}", "error")
                 except Exception as e:
@@ -996,7 +1165,8 @@ class NextKeyGUI:
                     self.server_url_var.get(),
                     self.project_uuid_var.get(),
                     self.aes_key_var.get(),
-                    scheme
+                    scheme,
+                    self.signing_public_key_var.get()
                 )
             except Exception as e:
                 messagebox.showerror("错误", f"初始化客户端失败: {e}")
@@ -0,0 +1,32 @@
+import request from './request'
+
+export function getAnnouncements(params) {
+  return request({
+    url: '/admin/announcements',
+    method: 'get',
+    params
+  })
+}
+
+export function createAnnouncement(data) {
+  return request({
+    url: '/admin/announcements',
+    method: 'post',
+    data
+  })
+}
+
+export function updateAnnouncement(id, data) {
+  return request({
+    url: `/admin/announcements/${id}`,
+    method: 'put',
+    data
+  })
+}
+
+export function deleteAnnouncement(id) {
+  return request({
+    url: `/admin/announcements/${id}`,
+    method: 'delete'
+  })
+}
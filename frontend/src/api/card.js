@@ -84,3 +84,34 @@ export function batchUnfreezeCards(data) {
   })
 }
 
+
+export function issueOfflineLicense(id, data) {
+  return request({
+    url: `/admin/cards/${id}/offline-license`,
+    method: 'post',
+    data
+  })
+}
+
+export function getOfflineLicenses(params) {
+  return request({
+    url: '/admin/offline-licenses',
+    method: 'get',
+    params
+  })
+}
+
+export function revokeOfflineLicense(id) {
+  return request({
+    url: `/admin/offline-licenses/${id}/revoke`,
+    method: 'post'
+  })
+}
+
+export function getCardTemplates(params) {
+  return request({
+    url: '/admin/card-templates',
+    method: 'get',
+    params
+  })
+}
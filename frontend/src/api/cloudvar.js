@@ -39,3 +39,50 @@ export function batchDeleteCloudVars(data) {
   })
 }
 
+export function getCloudVarVersions(id, params) {
+  return request({
+    url: `/admin/cloud-vars/${id}/versions`,
+    method: 'get',
+    params
+  })
+}
+
+export function diffCloudVarVersions(id, params) {
+  return request({
+    url: `/admin/cloud-vars/${id}/diff`,
+    method: 'get',
+    params
+  })
+}
+
+export function rollbackCloudVar(id, data) {
+  return request({
+    url: `/admin/cloud-vars/${id}/rollback`,
+    method: 'post',
+    data
+  })
+}
+
+export function getCloudVarSegments(id, params) {
+  return request({
+    url: `/admin/cloud-vars/${id}/segments`,
+    method: 'get',
+    params
+  })
+}
+
+export function exportCloudVars(params) {
+  return request({
+    url: '/admin/cloud-vars/export',
+    method: 'get',
+    params
+  })
+}
+
+export function importCloudVars(data) {
+  return request({
+    url: '/admin/cloud-vars/import',
+    method: 'post',
+    data
+  })
+}
@@ -31,9 +31,33 @@ export function deleteProject(id) {
   })
 }
 
+export function previewDeleteProjects(data) {
+  return request({
+    url: '/admin/projects/delete-preview',
+    method: 'post',
+    data
+  })
+}
+
+export function updateProjectStatus(id, data) {
+  return request({
+    url: `/admin/projects/${id}/status`,
+    method: 'put',
+    data
+  })
+}
+
+export function cloneProject(id, data) {
+  return request({
+    url: `/admin/projects/${id}/clone`,
+    method: 'post',
+    data
+  })
+}
+
 export function getProjectByUUID(uuid) {
   return request({
-    url: `/admin/projects/${uuid}`,
+    url: `/admin/projects/by-uuid/${uuid}`,
     method: 'get'
   })
 }
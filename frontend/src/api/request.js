@@ -49,7 +49,10 @@ request.interceptors.response.use(
         router.push('/login')
       }
       
-      return Promise.reject(new Error(res.message || '请求失败'))
+      // 附带响应数据，便于调用方展示明细（如批量设置的逐项错误）
+      const err = new Error(res.message || '请求失败')
+      err.data = res.data
+      return Promise.reject(err)
     }
     
     return res.data
@@ -0,0 +1,23 @@
+import request from './request'
+
+export function getTrash(params) {
+  return request({
+    url: '/admin/trash',
+    method: 'get',
+    params
+  })
+}
+
+export function restoreTrash(type, id) {
+  return request({
+    url: `/admin/trash/${type}/${id}/restore`,
+    method: 'post'
+  })
+}
+
+export function purgeTrash(type, id) {
+  return request({
+    url: `/admin/trash/${type}/${id}`,
+    method: 'delete'
+  })
+}
@@ -31,6 +31,16 @@ const routes = [
         path: '/cloudvars/:projectId?',
         name: 'CloudVars',
         component: () => import('@/views/CloudVars.vue')
+      },
+      {
+        path: '/announcements/:projectId?',
+        name: 'Announcements',
+        component: () => import('@/views/Announcements.vue')
+      },
+      {
+        path: '/trash',
+        name: 'Trash',
+        component: () => import('@/views/Trash.vue')
       }
     ]
   }
@@ -0,0 +1,10 @@
+// 项目运行状态的显示文案和标签颜色
+export const statusLabel = (status) => ({
+  maintenance: '维护中',
+  suspended: '已停用'
+}[status] || '正常')
+
+export const statusTagType = (status) => ({
+  maintenance: 'warning',
+  suspended: 'danger'
+}[status] || 'success')